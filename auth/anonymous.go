@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"go.uber.org/zap"
+
+	"github.com/beyondstorage/beyond-ftp/config"
+)
+
+// AnonymousAuthenticator authenticates the anonymous users, who log in with
+// any password. The password, usually an email address, is logged.
+type AnonymousAuthenticator struct {
+	settings *config.User
+}
+
+// NewAnonymousAuthenticator creates an authenticator for the anonymous policy.
+func NewAnonymousAuthenticator(a *config.Anonymous) *AnonymousAuthenticator {
+	return &AnonymousAuthenticator{settings: a.User()}
+}
+
+// LookupUser implements UserLookup.
+func (a *AnonymousAuthenticator) LookupUser(name string) (*config.User, bool) {
+	if !isAnonymous(name) {
+		return nil, false
+	}
+	return a.settings, true
+}
+
+// Authenticate implements Authenticator.
+func (a *AnonymousAuthenticator) Authenticate(req *Request) (*Identity, error) {
+	if !isAnonymous(req.User) {
+		return nil, ErrInvalidCredentials
+	}
+	if req.WithoutPassword {
+		return nil, ErrPasswordRequired
+	}
+
+	zap.L().Info("Anonymous login",
+		zap.String("user", req.User),
+		zap.String("email", req.Password),
+		zap.String("remote address", req.RemoteAddr),
+	)
+	return &Identity{User: req.User, Settings: a.settings}, nil
+}
+
+func isAnonymous(name string) bool {
+	for _, v := range config.AnonymousUsers {
+		if name == v {
+			return true
+		}
+	}
+	return false
+}
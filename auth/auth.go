@@ -0,0 +1,97 @@
+// Package auth authenticates the users logging in to the FTP server.
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+
+	"github.com/beyondstorage/beyond-ftp/config"
+)
+
+var (
+	// ErrPasswordRequired is returned when the user cannot be identified without password.
+	ErrPasswordRequired = errors.New("password is required")
+	// ErrInvalidCredentials is returned when the username or the password is wrong.
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	// ErrCertificateMismatch is returned when the client certificate does not match the user.
+	ErrCertificateMismatch = errors.New("client certificate does not match the user")
+)
+
+// Request is a login request from a client.
+type Request struct {
+	User       string               // Name of the user
+	Password   string               // Password of the user
+	RemoteAddr string               // Remote address of the client
+	TLS        *tls.ConnectionState // TLS state of the control connection, nil if it is in clear
+
+	// WithoutPassword is true when only USER is received. Authenticators should
+	// return ErrPasswordRequired unless the user could be identified by other
+	// means, such as the TLS client certificate.
+	WithoutPassword bool
+}
+
+// PeerCertificate returns the verified client certificate of the request, nil
+// if the client does not provide one.
+func (r *Request) PeerCertificate() *x509.Certificate {
+	return PeerCertificate(r.TLS)
+}
+
+// Identity is an authenticated user.
+type Identity struct {
+	User     string       // Name of the user
+	Settings *config.User // Configuration of the user, nil if the global settings apply
+}
+
+// Authenticator authenticates the login requests.
+type Authenticator interface {
+	// Authenticate returns the identity of the request, or an error if the request is refused.
+	Authenticate(req *Request) (*Identity, error)
+}
+
+// UserLookup is implemented by the authenticators which know the configuration
+// of their users. It is used to apply the per-user policies before login.
+type UserLookup interface {
+	// LookupUser returns the configuration of the user.
+	LookupUser(name string) (*config.User, bool)
+}
+
+// AuthenticatorFunc is an adapter to allow the use of ordinary functions as Authenticator.
+type AuthenticatorFunc func(req *Request) (*Identity, error)
+
+// Authenticate calls f(req).
+func (f AuthenticatorFunc) Authenticate(req *Request) (*Identity, error) {
+	return f(req)
+}
+
+// PeerCertificate returns the verified client certificate of the TLS
+// connection, nil if the client does not provide one.
+func PeerCertificate(state *tls.ConnectionState) *x509.Certificate {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return state.VerifiedChains[0][0]
+}
+
+// CertificateMatches reports whether the subject or any SAN of the certificate is one of the names.
+func CertificateMatches(cert *x509.Certificate, names []string) bool {
+	if cert == nil {
+		return false
+	}
+
+	candidates := []string{cert.Subject.String(), cert.Subject.CommonName}
+	candidates = append(candidates, cert.DNSNames...)
+	candidates = append(candidates, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		candidates = append(candidates, u.String())
+	}
+
+	for _, name := range names {
+		for _, candidate := range candidates {
+			if candidate != "" && name == candidate {
+				return true
+			}
+		}
+	}
+	return false
+}
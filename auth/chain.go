@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"errors"
+	"io"
+
+	"github.com/beyondstorage/beyond-ftp/config"
+)
+
+// ChainAuthenticator tries the authenticators in order. The next one is tried
+// only if the user is unknown or the credentials are refused, other errors,
+// such as a mismatching client certificate, end the chain.
+type ChainAuthenticator struct {
+	authenticators []Authenticator
+}
+
+// NewChainAuthenticator creates an authenticator trying the authenticators in order.
+func NewChainAuthenticator(authenticators ...Authenticator) *ChainAuthenticator {
+	return &ChainAuthenticator{authenticators: authenticators}
+}
+
+// Authenticate implements Authenticator.
+func (a *ChainAuthenticator) Authenticate(req *Request) (*Identity, error) {
+	err := ErrInvalidCredentials
+	for _, authenticator := range a.authenticators {
+		var identity *Identity
+		identity, err = authenticator.Authenticate(req)
+		if err == nil {
+			return identity, nil
+		}
+		if !errors.Is(err, ErrInvalidCredentials) && !errors.Is(err, ErrPasswordRequired) {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+// LookupUser implements UserLookup, the first authenticator knowing the user wins.
+func (a *ChainAuthenticator) LookupUser(name string) (*config.User, bool) {
+	for _, authenticator := range a.authenticators {
+		if lookup, ok := authenticator.(UserLookup); ok {
+			if u, ok := lookup.LookupUser(name); ok {
+				return u, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// Close closes the authenticators implementing io.Closer.
+func (a *ChainAuthenticator) Close() error {
+	var err error
+	for _, authenticator := range a.authenticators {
+		if c, ok := authenticator.(io.Closer); ok {
+			if cerr := c.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}
+	}
+	return err
+}
@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"go.uber.org/zap"
+
+	"github.com/beyondstorage/beyond-ftp/config"
+)
+
+// FileAuthenticator authenticates the users in a users file, and the users of
+// the config. The file is watched, and reloaded atomically when it changes.
+//
+// The format of the file is chosen by its extension:
+//   - ".toml": a table of users in the same format as the users table of the config.
+//   - ".json": an object of users, the value is either the password or an object.
+//   - others: htpasswd format, a "user:password" per line.
+type FileAuthenticator struct {
+	path      string
+	base      map[string]*config.User
+	anonymous *config.Anonymous
+	users     atomic.Value // *UsersAuthenticator
+
+	modTime time.Time
+	size    int64
+	done    chan struct{}
+}
+
+// NewFileAuthenticator loads the users file and watches it every interval.
+// The users in the file override the users with the same name in base. The
+// file is refused if its users take the names of the anonymous users while
+// they are enabled.
+func NewFileAuthenticator(path string, base map[string]*config.User, anonymous *config.Anonymous, interval time.Duration) (*FileAuthenticator, error) {
+	a := &FileAuthenticator{
+		path:      path,
+		base:      base,
+		anonymous: anonymous,
+		done:      make(chan struct{}),
+	}
+	if _, err := a.reload(); err != nil {
+		return nil, err
+	}
+
+	go a.watch(interval)
+	return a, nil
+}
+
+// Authenticate implements Authenticator.
+func (a *FileAuthenticator) Authenticate(req *Request) (*Identity, error) {
+	return a.current().Authenticate(req)
+}
+
+// LookupUser implements UserLookup.
+func (a *FileAuthenticator) LookupUser(name string) (*config.User, bool) {
+	return a.current().LookupUser(name)
+}
+
+// Close stops watching the users file.
+func (a *FileAuthenticator) Close() error {
+	close(a.done)
+	return nil
+}
+
+func (a *FileAuthenticator) current() *UsersAuthenticator {
+	return a.users.Load().(*UsersAuthenticator)
+}
+
+func (a *FileAuthenticator) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			reloaded, err := a.reload()
+			if err != nil {
+				zap.L().Error("Reload users file failed, keep the previous users",
+					zap.String("path", a.path), zap.Error(err))
+			} else if reloaded {
+				zap.L().Info("Users file reloaded", zap.String("path", a.path))
+			}
+		case <-a.done:
+			return
+		}
+	}
+}
+
+// reload loads the users file if it has changed since the last load.
+func (a *FileAuthenticator) reload() (bool, error) {
+	fi, err := os.Stat(a.path)
+	if err != nil {
+		return false, err
+	}
+	if fi.ModTime().Equal(a.modTime) && fi.Size() == a.size {
+		return false, nil
+	}
+	// Record the file even if it is invalid, so that the error is only reported once.
+	a.modTime, a.size = fi.ModTime(), fi.Size()
+
+	loaded, err := LoadUsersFile(a.path, a.anonymous)
+	if err != nil {
+		return false, err
+	}
+
+	users := make(map[string]*config.User, len(a.base)+len(loaded))
+	for name, u := range a.base {
+		users[name] = u
+	}
+	for name, u := range loaded {
+		users[name] = u
+	}
+	a.users.Store(NewUsersAuthenticator(users))
+	return true, nil
+}
+
+// LoadUsersFile loads the users from the file, see FileAuthenticator for the
+// formats. The users may take the names of the anonymous users only if
+// anonymous is not enabled.
+func LoadUsersFile(path string, anonymous *config.Anonymous) (map[string]*config.User, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	users := make(map[string]*config.User)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		err = toml.Unmarshal(content, &users)
+	case ".json":
+		err = json.Unmarshal(content, &users)
+	default:
+		users, err = parseHtpasswd(content)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load users file %s: %w", path, err)
+	}
+
+	for name, u := range users {
+		if u == nil {
+			users[name] = &config.User{}
+		}
+	}
+	if err = config.CheckUsers(users); err != nil {
+		return nil, fmt.Errorf("load users file %s: %w", path, err)
+	}
+	if err = config.CheckAnonymousUsers(users, anonymous); err != nil {
+		return nil, fmt.Errorf("load users file %s: %w", path, err)
+	}
+	if err = CheckPasswords(users); err != nil {
+		return nil, fmt.Errorf("load users file %s: %w", path, err)
+	}
+	return users, nil
+}
+
+func parseHtpasswd(content []byte) (map[string]*config.User, error) {
+	users := make(map[string]*config.User)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.IndexByte(line, ':')
+		if i <= 0 {
+			return nil, fmt.Errorf("line %d: expect user:password", n)
+		}
+		users[line[:i]] = &config.User{Password: line[i+1:]}
+	}
+	return users, scanner.Err()
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/beyondstorage/beyond-ftp/config"
+)
+
+func TestLoadUsersFile(t *testing.T) {
+	dir := t.TempDir()
+	cases := []struct {
+		name    string
+		content string
+	}{
+		{"users.htpasswd", "# comment\nalice:secret\n\nbob:{SHA512-CRYPT}$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1\n"},
+		{"users.toml", "alice = \"secret\"\n[bob]\npassword = \"{SHA512-CRYPT}$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1\"\nrequire-tls = true\n"},
+		{"users.json", `{"alice": "secret", "bob": {"password": "{SHA512-CRYPT}$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1", "require-tls": true}}`},
+	}
+	for _, c := range cases {
+		p := filepath.Join(dir, c.name)
+		assert.Nil(t, ioutil.WriteFile(p, []byte(c.content), 0600))
+
+		users, err := LoadUsersFile(p, nil)
+		assert.Nil(t, err, c.name)
+		assert.Equal(t, "secret", users["alice"].Password, c.name)
+		assert.True(t, ComparePassword(users["bob"].Password, "Hello world!"), c.name)
+	}
+
+	p := filepath.Join(dir, "invalid")
+	assert.Nil(t, ioutil.WriteFile(p, []byte("alice"), 0600))
+	_, err := LoadUsersFile(p, nil)
+	assert.NotNil(t, err)
+
+	// htpasswd defaults to MD5, which is not supported.
+	p = filepath.Join(dir, "md5.htpasswd")
+	assert.Nil(t, ioutil.WriteFile(p, []byte("alice:$apr1$salt$2R5lrKQyrPdmRdxfMoFnC0\n"), 0600))
+	_, err = LoadUsersFile(p, nil)
+	assert.EqualError(t, err, "load users file "+p+": user alice: unsupported password hash scheme $apr1$")
+
+	// The names of the anonymous users are refused while they are enabled,
+	// as in the users table.
+	p = filepath.Join(dir, "ftp.htpasswd")
+	assert.Nil(t, ioutil.WriteFile(p, []byte("ftp:secret\n"), 0600))
+	users, err := LoadUsersFile(p, &config.Anonymous{Enabled: false})
+	assert.Nil(t, err)
+	assert.Equal(t, "secret", users["ftp"].Password)
+	_, err = LoadUsersFile(p, &config.Anonymous{Enabled: true})
+	assert.EqualError(t, err, "load users file "+p+": user ftp: the name is taken by the anonymous users, which are enabled")
+}
+
+func TestFileAuthenticatorReload(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "users.json")
+	assert.Nil(t, ioutil.WriteFile(p, []byte(`{"alice": "v1"}`), 0600))
+
+	a, err := NewFileAuthenticator(p, map[string]*config.User{"admin": {Password: "admin"}}, nil, 10*time.Millisecond)
+	assert.Nil(t, err)
+	defer a.Close()
+
+	login := func(user, password string) bool {
+		_, err := a.Authenticate(&Request{User: user, Password: password})
+		return err == nil
+	}
+	assert.True(t, login("alice", "v1"))
+	assert.True(t, login("admin", "admin"))
+
+	assert.Nil(t, ioutil.WriteFile(p, []byte(`{"alice": "v2", "bob": "bob"}`), 0600))
+	// Make sure the change is visible even on file systems with coarse modification time.
+	assert.Nil(t, os.Chtimes(p, time.Now().Add(time.Second), time.Now().Add(time.Second)))
+	assert.Eventually(t, func() bool {
+		return login("alice", "v2") && login("bob", "bob")
+	}, time.Second, 10*time.Millisecond)
+
+	// Invalid content is ignored, and the previous users are kept.
+	assert.Nil(t, ioutil.WriteFile(p, []byte(`{"alice": `), 0600))
+	assert.Nil(t, os.Chtimes(p, time.Now().Add(2*time.Second), time.Now().Add(2*time.Second)))
+	time.Sleep(50 * time.Millisecond)
+	assert.True(t, login("alice", "v2"))
+	assert.False(t, login("alice", "v1"))
+	assert.True(t, login("admin", "admin"))
+}
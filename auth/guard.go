@@ -0,0 +1,223 @@
+package auth
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/beyondstorage/beyond-ftp/config"
+	"github.com/beyondstorage/beyond-ftp/utils"
+)
+
+// ErrBanned is returned when the client or the user is temporarily banned after too many failed logins.
+var ErrBanned = errors.New("too many failed logins, banned temporarily")
+
+// maxGuardEntries is the number of tracked IPs or users above which the forgotten ones are removed.
+const maxGuardEntries = 10000
+
+// Guard protects an authenticator against brute-force attacks. The failed
+// logins are counted per IP and per user, the replies of the failures are
+// delayed progressively, and the IP or the user is banned temporarily after
+// too many failures. The protection is disabled if the configuration is nil.
+type Guard struct {
+	mu            sync.Mutex
+	authenticator *guarded
+	config        *config.LoginProtection
+	ips           map[string]*failures
+	users         map[string]*failures
+	now           func() time.Time
+	sleep         func(time.Duration)
+}
+
+// guarded is the authenticator protected by a guard, it is closed once it is
+// replaced and its running calls end.
+type guarded struct {
+	Authenticator
+	calls sync.WaitGroup
+}
+
+type failures struct {
+	count       int
+	last        time.Time
+	bannedUntil time.Time
+}
+
+// NewGuard protects the authenticator with the configuration.
+func NewGuard(a Authenticator, c *config.LoginProtection) *Guard {
+	return &Guard{
+		authenticator: &guarded{Authenticator: a},
+		config:        c,
+		ips:           make(map[string]*failures),
+		users:         make(map[string]*failures),
+		now:           time.Now,
+		sleep:         time.Sleep,
+	}
+}
+
+// Authenticate implements Authenticator.
+func (g *Guard) Authenticate(req *Request) (*Identity, error) {
+	ip := utils.AddrIP(req.RemoteAddr).String()
+	if g.banned(ip, req.User) {
+		return nil, ErrBanned
+	}
+
+	a := g.acquire()
+	identity, err := a.Authenticate(req)
+	a.calls.Done()
+	if err == nil {
+		g.mu.Lock()
+		delete(g.users, req.User)
+		g.mu.Unlock()
+		return identity, nil
+	}
+	if !errors.Is(err, ErrInvalidCredentials) && !errors.Is(err, ErrCertificateMismatch) {
+		return nil, err
+	}
+
+	if delay := g.fail(ip, req.User); delay > 0 {
+		g.sleep(delay)
+	}
+	return nil, err
+}
+
+// LookupUser implements UserLookup.
+func (g *Guard) LookupUser(name string) (*config.User, bool) {
+	a := g.acquire()
+	defer a.calls.Done()
+	if lookup, ok := a.Authenticator.(UserLookup); ok {
+		return lookup.LookupUser(name)
+	}
+	return nil, false
+}
+
+// Reload replaces the protected authenticator and the configuration, the
+// failed logins and the bans are kept. The previous authenticator is closed
+// once its running calls end.
+func (g *Guard) Reload(a Authenticator, c *config.LoginProtection) {
+	g.mu.Lock()
+	old := g.authenticator
+	g.authenticator = &guarded{Authenticator: a}
+	g.config = c
+	g.mu.Unlock()
+
+	go func() {
+		old.calls.Wait()
+		if c, ok := old.Authenticator.(io.Closer); ok {
+			c.Close()
+		}
+	}()
+}
+
+// SetConfig replaces the configuration of the protection, the protected
+// authenticator and the failed logins are kept.
+func (g *Guard) SetConfig(c *config.LoginProtection) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.config = c
+}
+
+// Close closes the authenticator if it implements io.Closer.
+func (g *Guard) Close() error {
+	a := g.acquire()
+	a.calls.Done()
+	if c, ok := a.Authenticator.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// acquire returns the current authenticator, the caller must mark the call
+// done after using it.
+func (g *Guard) acquire() *guarded {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.authenticator.calls.Add(1)
+	return g.authenticator
+}
+
+func (g *Guard) banned(ip, user string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	for _, f := range []*failures{g.ips[ip], g.users[user]} {
+		if f != nil && now.Before(f.bannedUntil) {
+			return true
+		}
+	}
+	return false
+}
+
+// fail records a failed login, and returns the delay of the reply.
+func (g *Guard) fail(ip, user string) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.config == nil {
+		return 0
+	}
+	now := g.now()
+	count := 0
+	for _, key := range []struct {
+		m    map[string]*failures
+		name string
+		kind string
+	}{{g.ips, ip, "ip"}, {g.users, user, "user"}} {
+		f := g.record(key.m, key.name, now)
+		if f.count > count {
+			count = f.count
+		}
+
+		zap.L().Debug("Login failure recorded",
+			zap.String("event", "login_failure"),
+			zap.String(key.kind, key.name),
+			zap.Int("failures", f.count),
+		)
+		if g.config.MaxFailures > 0 && f.count >= g.config.MaxFailures {
+			f.bannedUntil = now.Add(time.Duration(g.config.BanDuration) * time.Second)
+			f.count = 0
+			zap.L().Warn("Login banned",
+				zap.String("event", "login_ban"),
+				zap.String(key.kind, key.name),
+				zap.String("ip", ip),
+				zap.String("user", user),
+				zap.Time("until", f.bannedUntil),
+			)
+		}
+	}
+
+	if g.config.Delay <= 0 {
+		return 0
+	}
+	delay := time.Duration(g.config.Delay*count) * time.Millisecond
+	if max := time.Duration(g.config.MaxDelay) * time.Millisecond; delay > max {
+		delay = max
+	}
+	return delay
+}
+
+func (g *Guard) record(m map[string]*failures, name string, now time.Time) *failures {
+	window := time.Duration(g.config.FailureWindow) * time.Second
+	if len(m) >= maxGuardEntries {
+		for k, f := range m {
+			if now.Sub(f.last) > window && now.After(f.bannedUntil) {
+				delete(m, k)
+			}
+		}
+	}
+
+	f, ok := m[name]
+	if !ok {
+		f = &failures{}
+		m[name] = f
+	}
+	if now.Sub(f.last) > window {
+		f.count = 0
+	}
+	f.count++
+	f.last = now
+	return f
+}
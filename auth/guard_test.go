@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/beyondstorage/beyond-ftp/config"
+)
+
+func TestGuard(t *testing.T) {
+	g := NewGuard(NewUsersAuthenticator(map[string]*config.User{
+		"alice": {Password: "alice"},
+		"bob":   {Password: "bob"},
+	}), &config.LoginProtection{
+		MaxFailures:   3,
+		FailureWindow: 60,
+		BanDuration:   300,
+		Delay:         100,
+		MaxDelay:      250,
+	})
+	now := time.Unix(0, 0)
+	g.now = func() time.Time { return now }
+	var delays []time.Duration
+	g.sleep = func(d time.Duration) { delays = append(delays, d) }
+
+	login := func(user, password, addr string) error {
+		_, err := g.Authenticate(&Request{User: user, Password: password, RemoteAddr: addr})
+		return err
+	}
+
+	// The failures of a user are counted across the IPs, the delay grows.
+	assert.Equal(t, ErrInvalidCredentials, login("alice", "wrong", "192.0.2.1:1000"))
+	assert.Equal(t, ErrInvalidCredentials, login("alice", "wrong", "192.0.2.2:1000"))
+	assert.Equal(t, []time.Duration{100 * time.Millisecond, 200 * time.Millisecond}, delays)
+	assert.Equal(t, ErrInvalidCredentials, login("alice", "wrong", "192.0.2.3:1000"))
+	assert.Equal(t, 250*time.Millisecond, delays[2])
+	assert.Equal(t, ErrBanned, login("alice", "alice", "192.0.2.4:1000"))
+	assert.Nil(t, login("bob", "bob", "192.0.2.4:1000"))
+
+	// The ban expires.
+	now = now.Add(301 * time.Second)
+	assert.Nil(t, login("alice", "alice", "192.0.2.4:1000"))
+
+	// The failures from an IP are counted across the users, and the forms of the IP.
+	assert.Equal(t, ErrInvalidCredentials, login("u1", "wrong", "198.51.100.1:1000"))
+	assert.Equal(t, ErrInvalidCredentials, login("u2", "wrong", "198.51.100.1:1001"))
+	assert.Equal(t, ErrInvalidCredentials, login("u3", "wrong", "[::ffff:198.51.100.1]:1002"))
+	assert.Equal(t, ErrBanned, login("bob", "bob", "198.51.100.1:1003"))
+
+	// The failures are forgotten after the window.
+	assert.Equal(t, ErrInvalidCredentials, login("bob", "wrong", "203.0.113.1:1000"))
+	assert.Equal(t, ErrInvalidCredentials, login("bob", "wrong", "203.0.113.1:1000"))
+	now = now.Add(61 * time.Second)
+	assert.Equal(t, ErrInvalidCredentials, login("bob", "wrong", "203.0.113.1:1000"))
+	assert.Nil(t, login("bob", "bob", "203.0.113.1:1000"))
+}
+
+type closingAuthenticator struct {
+	*UsersAuthenticator
+	closed chan struct{}
+}
+
+func (a *closingAuthenticator) Close() error {
+	close(a.closed)
+	return nil
+}
+
+func TestGuardReload(t *testing.T) {
+	old := &closingAuthenticator{
+		UsersAuthenticator: NewUsersAuthenticator(map[string]*config.User{"alice": {Password: "alice"}}),
+		closed:             make(chan struct{}),
+	}
+	g := NewGuard(old, &config.LoginProtection{MaxFailures: 2, FailureWindow: 60, BanDuration: 300})
+	login := func(user, password, addr string) error {
+		_, err := g.Authenticate(&Request{User: user, Password: password, RemoteAddr: addr})
+		return err
+	}
+	assert.Equal(t, ErrInvalidCredentials, login("alice", "wrong", "192.0.2.1:1000"))
+	assert.Equal(t, ErrInvalidCredentials, login("alice", "wrong", "192.0.2.1:1001"))
+
+	// The bans are kept, the previous authenticator is closed once it is not used.
+	running := g.acquire()
+	g.Reload(NewUsersAuthenticator(map[string]*config.User{"bob": {Password: "bob"}}), nil)
+	select {
+	case <-old.closed:
+		t.Fatal("authenticator closed while it is used")
+	case <-time.After(10 * time.Millisecond):
+	}
+	running.calls.Done()
+	<-old.closed
+
+	assert.Equal(t, ErrBanned, login("bob", "bob", "192.0.2.1:1002"))
+	assert.Equal(t, ErrBanned, login("alice", "alice", "198.51.100.1:1000"))
+	assert.Nil(t, login("bob", "bob", "198.51.100.1:1001"))
+
+	// The failures are not counted without protection.
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, ErrInvalidCredentials, login("bob", "wrong", "203.0.113.1:1000"))
+	}
+	assert.Nil(t, login("bob", "bob", "203.0.113.1:1000"))
+
+	// The configuration is replaced alone, the authenticator is kept.
+	g.SetConfig(&config.LoginProtection{MaxFailures: 1, FailureWindow: 60, BanDuration: 300})
+	assert.Equal(t, ErrInvalidCredentials, login("bob", "wrong", "203.0.113.2:1000"))
+	assert.Equal(t, ErrBanned, login("bob", "bob", "203.0.113.3:1000"))
+}
@@ -0,0 +1,189 @@
+package auth
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+
+	"github.com/beyondstorage/beyond-ftp/config"
+)
+
+// LDAPAuthenticator authenticates the users by binding to a LDAP server.
+type LDAPAuthenticator struct {
+	config    *config.LDAP
+	tlsConfig *tls.Config
+	timeout   time.Duration
+	groups    []*ldap.DN // Parsed DNs of config.Groups
+}
+
+// NewLDAPAuthenticator creates an authenticator for the LDAP server.
+func NewLDAPAuthenticator(c *config.LDAP) (*LDAPAuthenticator, error) {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return nil, fmt.Errorf("ldap: invalid url %s: %w", c.URL, err)
+	}
+
+	a := &LDAPAuthenticator{
+		config: c,
+		tlsConfig: &tls.Config{
+			ServerName:         u.Hostname(),
+			InsecureSkipVerify: c.InsecureSkipVerify,
+		},
+		timeout: time.Duration(c.Timeout) * time.Second,
+	}
+	for _, g := range c.Groups {
+		dn, err := ldap.ParseDN(g.DN)
+		if err != nil {
+			return nil, fmt.Errorf("ldap: invalid group %s: %w", g.DN, err)
+		}
+		a.groups = append(a.groups, dn)
+	}
+	return a, nil
+}
+
+// Authenticate implements Authenticator.
+func (a *LDAPAuthenticator) Authenticate(req *Request) (*Identity, error) {
+	if req.WithoutPassword {
+		return nil, ErrPasswordRequired
+	}
+	// An empty password makes an unauthenticated bind, which always succeeds.
+	if req.Password == "" || req.User == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := a.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	userDN, err := a.userDN(conn, req.User)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.Bind(userDN, req.Password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("ldap: bind %s: %w", userDN, err)
+	}
+
+	settings, err := a.profile(conn, userDN)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{User: req.User, Settings: settings}, nil
+}
+
+func (a *LDAPAuthenticator) dial() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(a.config.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: a.timeout}),
+		ldap.DialWithTLSConfig(a.tlsConfig),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("ldap: dial %s: %w", a.config.URL, err)
+	}
+	conn.SetTimeout(a.timeout)
+
+	if a.config.StartTLS {
+		if err := conn.StartTLS(a.tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("ldap: start tls: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// searchBind binds with the search DN, or keeps the connection anonymous if it is not configured.
+func (a *LDAPAuthenticator) searchBind(conn *ldap.Conn) error {
+	if a.config.SearchBindDN == "" {
+		return nil
+	}
+	if err := conn.Bind(a.config.SearchBindDN, a.config.SearchBindPassword); err != nil {
+		return fmt.Errorf("ldap: bind %s: %w", a.config.SearchBindDN, err)
+	}
+	return nil
+}
+
+// userDN returns the DN of the user, built from the template or searched.
+func (a *LDAPAuthenticator) userDN(conn *ldap.Conn, user string) (string, error) {
+	if a.config.BindDN != "" {
+		return fmt.Sprintf(a.config.BindDN, escapeDN(user)), nil
+	}
+
+	if err := a.searchBind(conn); err != nil {
+		return "", err
+	}
+	res, err := conn.Search(ldap.NewSearchRequest(
+		a.config.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, int(a.timeout/time.Second), false,
+		fmt.Sprintf(a.config.UserFilter, ldap.EscapeFilter(user)),
+		[]string{"dn"}, nil,
+	))
+	if err != nil {
+		return "", fmt.Errorf("ldap: search user %s: %w", user, err)
+	}
+	if len(res.Entries) != 1 {
+		return "", fmt.Errorf("%w: %d entries found for the user", ErrInvalidCredentials, len(res.Entries))
+	}
+	return res.Entries[0].DN, nil
+}
+
+// profile checks the groups of the user, and returns the profile of the first
+// configured group the user belongs to.
+func (a *LDAPAuthenticator) profile(conn *ldap.Conn, userDN string) (*config.User, error) {
+	if a.config.GroupFilter == "" {
+		return nil, nil
+	}
+
+	// The user may not be allowed to search the groups.
+	if err := a.searchBind(conn); err != nil {
+		return nil, err
+	}
+	res, err := conn.Search(ldap.NewSearchRequest(
+		a.config.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		0, int(a.timeout/time.Second), false,
+		fmt.Sprintf(a.config.GroupFilter, ldap.EscapeFilter(userDN)),
+		[]string{"dn"}, nil,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("ldap: search groups of %s: %w", userDN, err)
+	}
+	if len(res.Entries) == 0 {
+		return nil, fmt.Errorf("%w: user is not in any group", ErrInvalidCredentials)
+	}
+
+	for i, group := range a.groups {
+		for _, entry := range res.Entries {
+			dn, err := ldap.ParseDN(entry.DN)
+			if err == nil && group.EqualFold(dn) {
+				return a.config.Groups[i].Profile, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// escapeDN escapes the special characters of an attribute value in a DN, as described in RFC 4514.
+func escapeDN(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case ch == 0:
+			b.WriteString(`\00`)
+			continue
+		case strings.IndexByte(`"+,;<>\`, ch) >= 0,
+			i == 0 && (ch == ' ' || ch == '#'),
+			i == len(s)-1 && ch == ' ':
+			b.WriteByte('\\')
+		}
+		b.WriteByte(ch)
+	}
+	return b.String()
+}
@@ -0,0 +1,229 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/beyondstorage/beyond-ftp/config"
+)
+
+// ldapServer is a minimal in-process LDAP server, supporting simple binds and
+// searches answered from a table of filters.
+type ldapServer struct {
+	listener  net.Listener
+	passwords map[string]string   // Password of the DNs
+	results   map[string][]string // DNs found by the filters
+	searchDN  string              // DN required to search, anonymous searches are allowed if empty
+}
+
+func newLDAPServer(t *testing.T) *ldapServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &ldapServer{
+		listener:  l,
+		passwords: make(map[string]string),
+		results:   make(map[string][]string),
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *ldapServer) URL() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+func (s *ldapServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	bound := ""
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		id := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			dn := op.Children[1].Value.(string)
+			password := op.Children[2].Data.String()
+			code := uint16(ldap.LDAPResultInvalidCredentials)
+			if p, ok := s.passwords[dn]; ok && p == password {
+				code = ldap.LDAPResultSuccess
+				bound = dn
+			}
+			s.reply(conn, id, ldapResult(ldap.ApplicationBindResponse, code))
+		case ldap.ApplicationSearchRequest:
+			if s.searchDN != "" && bound != s.searchDN {
+				s.reply(conn, id, ldapResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultInsufficientAccessRights))
+				continue
+			}
+			filter, err := ldap.DecompileFilter(op.Children[6])
+			if err != nil {
+				s.reply(conn, id, ldapResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultProtocolError))
+				continue
+			}
+			for _, dn := range s.results[filter] {
+				entry := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "")
+				entry.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, ""))
+				entry.AppendChild(ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, ""))
+				s.reply(conn, id, entry)
+			}
+			s.reply(conn, id, ldapResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess))
+		case ldap.ApplicationUnbindRequest:
+			return
+		default:
+			panic(fmt.Sprintf("unexpected LDAP request %d", op.Tag))
+		}
+	}
+}
+
+func (s *ldapServer) reply(w io.Writer, id int64, op *ber.Packet) {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+	packet.AppendChild(op)
+	_, _ = w.Write(packet.Bytes())
+}
+
+func ldapResult(tag ber.Tag, code uint16) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	return op
+}
+
+const (
+	aliceDN   = "uid=alice,ou=people,dc=example,dc=com"
+	bobDN     = "uid=bob,ou=people,dc=example,dc=com"
+	serviceDN = "cn=ftp,ou=services,dc=example,dc=com"
+)
+
+func TestLDAPAuthenticatorBindDN(t *testing.T) {
+	s := newLDAPServer(t)
+	s.passwords[aliceDN] = "alice-secret"
+
+	a, err := NewLDAPAuthenticator(&config.LDAP{
+		URL:     s.URL(),
+		Timeout: 5,
+		BindDN:  "uid=%s,ou=people,dc=example,dc=com",
+	})
+	assert.Nil(t, err)
+
+	identity, err := a.Authenticate(&Request{User: "alice", Password: "alice-secret"})
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", identity.User)
+	assert.Nil(t, identity.Settings)
+
+	_, err = a.Authenticate(&Request{User: "alice", Password: "wrong"})
+	assert.Equal(t, ErrInvalidCredentials, err)
+	_, err = a.Authenticate(&Request{User: "alice"})
+	assert.Equal(t, ErrInvalidCredentials, err)
+	_, err = a.Authenticate(&Request{User: "alice", WithoutPassword: true})
+	assert.Equal(t, ErrPasswordRequired, err)
+}
+
+func TestLDAPAuthenticatorSearchAndGroups(t *testing.T) {
+	s := newLDAPServer(t)
+	s.searchDN = serviceDN
+	s.passwords[serviceDN] = "service-secret"
+	s.passwords[aliceDN] = "alice-secret"
+	s.passwords[bobDN] = "bob-secret"
+	s.passwords["uid=carol,ou=people,dc=example,dc=com"] = "carol-secret"
+	s.results["(uid=alice)"] = []string{aliceDN}
+	s.results["(uid=bob)"] = []string{bobDN}
+	s.results["(uid=carol)"] = []string{"uid=carol,ou=people,dc=example,dc=com"}
+	s.results["(member="+aliceDN+")"] = []string{"cn=staff,ou=groups,dc=example,dc=com", "cn=partners,ou=groups,dc=example,dc=com"}
+	s.results["(member="+bobDN+")"] = []string{"cn=staff,ou=groups,dc=example,dc=com"}
+
+	requireTLS := true
+	partners := &config.User{RequireTLS: &requireTLS}
+	c := &config.LDAP{
+		URL:                s.URL(),
+		Timeout:            5,
+		SearchBindDN:       serviceDN,
+		SearchBindPassword: "service-secret",
+		BaseDN:             "dc=example,dc=com",
+		UserFilter:         "(uid=%s)",
+		GroupFilter:        "(member=%s)",
+		Groups: []*config.LDAPGroup{
+			{DN: "CN=Partners,OU=Groups,DC=Example,DC=Com", Profile: partners},
+		},
+	}
+	a, err := NewLDAPAuthenticator(c)
+	assert.Nil(t, err)
+
+	identity, err := a.Authenticate(&Request{User: "alice", Password: "alice-secret"})
+	assert.Nil(t, err)
+	assert.Equal(t, partners, identity.Settings)
+
+	identity, err = a.Authenticate(&Request{User: "bob", Password: "bob-secret"})
+	assert.Nil(t, err)
+	assert.Nil(t, identity.Settings)
+
+	// Carol is not in any group.
+	_, err = a.Authenticate(&Request{User: "carol", Password: "carol-secret"})
+	assert.True(t, errors.Is(err, ErrInvalidCredentials))
+
+	_, err = a.Authenticate(&Request{User: "dave", Password: "dave-secret"})
+	assert.True(t, errors.Is(err, ErrInvalidCredentials))
+	_, err = a.Authenticate(&Request{User: "bob", Password: "alice-secret"})
+	assert.Equal(t, ErrInvalidCredentials, err)
+}
+
+func TestChainAuthenticator(t *testing.T) {
+	s := newLDAPServer(t)
+	s.passwords[aliceDN] = "alice-secret"
+
+	ldapAuthenticator, err := NewLDAPAuthenticator(&config.LDAP{
+		URL:     s.URL(),
+		Timeout: 5,
+		BindDN:  "uid=%s,ou=people,dc=example,dc=com",
+	})
+	assert.Nil(t, err)
+	a := NewChainAuthenticator(NewUsersAuthenticator(map[string]*config.User{
+		"test":    {Password: "test"},
+		"machine": {ClientCert: config.ClientCertSufficient, CertNames: []string{"machine"}},
+	}), ldapAuthenticator)
+
+	_, err = a.Authenticate(&Request{User: "test", Password: "test"})
+	assert.Nil(t, err)
+	_, err = a.Authenticate(&Request{User: "alice", Password: "alice-secret"})
+	assert.Nil(t, err)
+	_, err = a.Authenticate(&Request{User: "alice", WithoutPassword: true})
+	assert.Equal(t, ErrPasswordRequired, err)
+	_, err = a.Authenticate(&Request{User: "alice", Password: "test"})
+	assert.Equal(t, ErrInvalidCredentials, err)
+	_, err = a.Authenticate(&Request{User: "machine", Password: "alice-secret"})
+	assert.Equal(t, ErrCertificateMismatch, err)
+
+	u, ok := a.LookupUser("test")
+	assert.True(t, ok)
+	assert.Equal(t, "test", u.Password)
+}
+
+func TestEscapeDN(t *testing.T) {
+	assert.Equal(t, "alice", escapeDN("alice"))
+	assert.Equal(t, `\#a\,b\+c\ `, escapeDN("#a,b+c "))
+	assert.Equal(t, `\ a\\\00`, escapeDN(" a\\\x00"))
+}
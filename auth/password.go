@@ -0,0 +1,214 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/beyondstorage/beyond-ftp/config"
+)
+
+// Algorithms supported by HashPassword.
+const (
+	AlgorithmBcrypt      = "bcrypt"
+	AlgorithmArgon2id    = "argon2id"
+	AlgorithmSHA512Crypt = "sha512-crypt"
+	AlgorithmSHA256Crypt = "sha256-crypt"
+)
+
+// Parameters of the generated argon2id hashes.
+const (
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024
+	argon2Threads = 4
+	argon2KeyLen  = 32
+	argon2SaltLen = 16
+)
+
+// Limits of the parameters of the stored argon2 hashes, the hashes out of
+// them are invalid.
+const (
+	argon2MaxMemory  = 4 << 20 // KiB
+	argon2MaxTime    = 64
+	argon2MinSaltLen = 8
+	argon2MinKeyLen  = 16
+	argon2MaxKeyLen  = 64
+)
+
+// hashScheme matches the scheme of a hash in modular crypt format ($id$) or
+// prefixed by {SCHEME}.
+var hashScheme = regexp.MustCompile(`^(\$[0-9a-z-]+\$|\{[0-9A-Z.-]+\})`)
+
+// supportedSchemes are the hash schemes known by ComparePassword.
+var supportedSchemes = []string{
+	"$2a$", "$2b$", "$2y$",
+	"$argon2id$", "$argon2i$",
+	"$5$", "$6$", "{SHA256-CRYPT}", "{SHA512-CRYPT}",
+}
+
+// ComparePassword reports whether the password matches the stored one. The
+// stored password is recognized by its prefix as a bcrypt ($2a$, $2b$, $2y$),
+// argon2 ($argon2id$, $argon2i$) or SHA-crypt ($5$, $6$, {SHA256-CRYPT},
+// {SHA512-CRYPT}) hash. The hashes of the other schemes match no password,
+// the rest is compared as plaintext. All the comparisons are in constant time.
+func ComparePassword(stored, password string) bool {
+	switch {
+	case strings.HasPrefix(stored, "$2a$"), strings.HasPrefix(stored, "$2b$"), strings.HasPrefix(stored, "$2y$"):
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) == nil
+	case strings.HasPrefix(stored, "$argon2id$"), strings.HasPrefix(stored, "$argon2i$"):
+		return compareArgon2(stored, password)
+	case strings.HasPrefix(stored, "{SHA512-CRYPT}"), strings.HasPrefix(stored, "{SHA256-CRYPT}"):
+		return compareSHACrypt(stored[len("{SHA512-CRYPT}"):], password)
+	case strings.HasPrefix(stored, "$5$"), strings.HasPrefix(stored, "$6$"):
+		return compareSHACrypt(stored, password)
+	case unsupportedScheme(stored) != "":
+		return false
+	default:
+		return subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+	}
+}
+
+// HashPassword hashes the password with the algorithm.
+func HashPassword(password, algorithm string) (string, error) {
+	switch algorithm {
+	case AlgorithmBcrypt:
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		return string(hash), err
+	case AlgorithmArgon2id:
+		salt := make([]byte, argon2SaltLen)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+			argon2.Version, argon2Memory, argon2Time, argon2Threads,
+			base64.RawStdEncoding.EncodeToString(salt),
+			base64.RawStdEncoding.EncodeToString(key),
+		), nil
+	case AlgorithmSHA512Crypt, AlgorithmSHA256Crypt:
+		salt := make([]byte, shaCryptSaltLen)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		for i := range salt {
+			salt[i] = shaCryptAlphabet[int(salt[i])%len(shaCryptAlphabet)]
+		}
+		prefix := "$6$"
+		if algorithm == AlgorithmSHA256Crypt {
+			prefix = "$5$"
+		}
+		return shaCrypt(prefix+string(salt), password)
+	default:
+		return "", fmt.Errorf("unsupported password hash algorithm %q", algorithm)
+	}
+}
+
+// CheckPasswords checks the stored password hashes of the users.
+func CheckPasswords(users map[string]*config.User) error {
+	for name, u := range users {
+		if err := CheckPasswordHash(u.Password); err != nil {
+			return fmt.Errorf("user %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// CheckPasswordHash checks a stored password. The hashes of unsupported
+// schemes and the argon2 hashes with invalid parameters are refused, the
+// plaintext passwords are not checked.
+func CheckPasswordHash(stored string) error {
+	if strings.HasPrefix(stored, "$argon2id$") || strings.HasPrefix(stored, "$argon2i$") {
+		_, err := parseArgon2(stored)
+		return err
+	}
+	if scheme := unsupportedScheme(stored); scheme != "" {
+		return fmt.Errorf("unsupported password hash scheme %s", scheme)
+	}
+	return nil
+}
+
+// unsupportedScheme returns the scheme of the stored password if it's a hash
+// ComparePassword doesn't support, an empty string otherwise.
+func unsupportedScheme(stored string) string {
+	scheme := hashScheme.FindString(stored)
+	for _, s := range supportedSchemes {
+		if scheme == s {
+			return ""
+		}
+	}
+	return scheme
+}
+
+// argon2Hash is a stored argon2 hash.
+type argon2Hash struct {
+	id      bool // argon2id, argon2i otherwise
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+// parseArgon2 parses the hash in PHC string format:
+// $argon2id$v=19$m=65536,t=3,p=4$<salt>$<key>
+func parseArgon2(stored string) (*argon2Hash, error) {
+	parts := strings.Split(stored, "$")
+	if len(parts) != 6 {
+		return nil, errors.New("invalid argon2 hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, fmt.Errorf("unsupported argon2 version %q", parts[2])
+	}
+	h := &argon2Hash{id: parts[1] == "argon2id"}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.time, &h.threads); err != nil {
+		return nil, fmt.Errorf("invalid argon2 parameters %q", parts[3])
+	}
+	if h.threads == 0 || h.time == 0 || h.time > argon2MaxTime ||
+		h.memory < 8*uint32(h.threads) || h.memory > argon2MaxMemory {
+		return nil, fmt.Errorf("argon2 parameters %q out of range", parts[3])
+	}
+
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil || len(h.salt) < argon2MinSaltLen {
+		return nil, errors.New("invalid argon2 salt")
+	}
+	if h.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil ||
+		len(h.key) < argon2MinKeyLen || len(h.key) > argon2MaxKeyLen {
+		return nil, errors.New("invalid argon2 key")
+	}
+	return h, nil
+}
+
+// compareArgon2 compares the password with the argon2 hash, the invalid
+// hashes match no password.
+func compareArgon2(stored, password string) bool {
+	h, err := parseArgon2(stored)
+	if err != nil {
+		return false
+	}
+
+	var actual []byte
+	if h.id {
+		actual = argon2.IDKey([]byte(password), h.salt, h.time, h.memory, h.threads, uint32(len(h.key)))
+	} else {
+		actual = argon2.Key([]byte(password), h.salt, h.time, h.memory, h.threads, uint32(len(h.key)))
+	}
+	return subtle.ConstantTimeCompare(h.key, actual) == 1
+}
+
+func compareSHACrypt(stored, password string) bool {
+	actual, err := shaCrypt(stored, password)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(stored), []byte(actual)) == 1
+}
@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/beyondstorage/beyond-ftp/config"
+)
+
+func TestSHACrypt(t *testing.T) {
+	// Test vectors from https://www.akkadia.org/drepper/SHA-crypt.txt
+	cases := []struct {
+		setting  string
+		password string
+		expected string
+	}{
+		{"$5$saltstring", "Hello world!", "$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5"},
+		{"$5$rounds=10000$saltstringsaltstring", "Hello world!", "$5$rounds=10000$saltstringsaltst$3xv.VbSHBb41AL9AvLeujZkZRBAwqFMz2.opqey6IcA"},
+		{"$6$saltstring", "Hello world!", "$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1"},
+		{"$6$rounds=10000$saltstringsaltstring", "Hello world!", "$6$rounds=10000$saltstringsaltst$OW1/O6BYHV6BcXZu8QVeXbDWra3Oeqh0sbHbbMCVNSnCM/UrjmM0Dp8vOuZeHBy/YTBmSK6H9qs/y3RnOaw5v."},
+	}
+	for _, c := range cases {
+		actual, err := shaCrypt(c.setting, c.password)
+		assert.Nil(t, err)
+		assert.Equal(t, c.expected, actual)
+	}
+}
+
+func TestComparePassword(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmBcrypt, AlgorithmArgon2id, AlgorithmSHA512Crypt, AlgorithmSHA256Crypt} {
+		hash, err := HashPassword("secret", algorithm)
+		assert.Nil(t, err, algorithm)
+		assert.True(t, ComparePassword(hash, "secret"), algorithm)
+		assert.False(t, ComparePassword(hash, "wrong"), algorithm)
+	}
+
+	// htpasswd generates bcrypt hashes with $2y$.
+	hash, err := HashPassword("secret", AlgorithmBcrypt)
+	assert.Nil(t, err)
+	assert.True(t, ComparePassword("$2y$"+hash[4:], "secret"))
+
+	cases := []struct {
+		stored   string
+		password string
+		expected bool
+	}{
+		{"plain", "plain", true},
+		{"plain", "plain2", false},
+		{"", "", true},
+		{"{SHA512-CRYPT}$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1", "Hello world!", true},
+		{"{SHA512-CRYPT}$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1", "Hello world", false},
+		{"$argon2id$v=19$m=65536,t=2,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc", "password", true},
+		{"$argon2id$v=19$m=65536,t=2,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc", "drowssap", false},
+		{"$argon2id$broken", "password", false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.expected, ComparePassword(c.stored, c.password), c.stored)
+	}
+}
+
+func TestCheckPasswordHash(t *testing.T) {
+	valid := "$argon2id$v=19$m=65536,t=2,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc"
+	assert.Nil(t, CheckPasswordHash(valid))
+	assert.Nil(t, CheckPasswordHash("plain"))
+
+	// The invalid hashes are refused, and match no password.
+	for _, stored := range []string{
+		"$argon2id$v=19$m=65536,t=2,p=0$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc",
+		"$argon2id$v=19$m=65536,t=0,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc",
+		"$argon2id$v=19$m=0,t=2,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc",
+		"$argon2id$v=19$m=4294967295,t=2,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc",
+		"$argon2id$v=19$m=65536,t=2,p=1$c29tZXNhbHQ$",
+		"$argon2i$v=19$m=65536,t=2,p=1$c29tZXNhbHQ$",
+		"$argon2id$v=19$m=65536,t=2,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMa",
+	} {
+		assert.NotNil(t, CheckPasswordHash(stored), stored)
+		assert.False(t, ComparePassword(stored, "password"), stored)
+		assert.False(t, ComparePassword(stored, ""), stored)
+	}
+
+	err := CheckPasswords(map[string]*config.User{"u": {Password: "$argon2id$v=19$m=65536,t=2,p=1$c29tZXNhbHQ$"}})
+	assert.EqualError(t, err, "user u: invalid argon2 key")
+
+	// The hashes of unsupported schemes are not compared as plaintext.
+	for _, stored := range []string{
+		"$apr1$salt$2R5lrKQyrPdmRdxfMoFnC0",
+		"$1$salt$qJH7.N4xYta3aEG/dfqo/0",
+		"{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=",
+		"{SSHA}c2FsdHNhbHRzYWx0c2FsdA==",
+	} {
+		assert.NotNil(t, CheckPasswordHash(stored), stored)
+		assert.False(t, ComparePassword(stored, stored), stored)
+	}
+	err = CheckPasswords(map[string]*config.User{"u": {Password: "{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g="}})
+	assert.EqualError(t, err, "user u: unsupported password hash scheme {SHA}")
+}
@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+// SHA-crypt is implemented according to https://www.akkadia.org/drepper/SHA-crypt.txt
+
+const (
+	shaCryptAlphabet      = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	shaCryptSaltLen       = 16
+	shaCryptRoundsDefault = 5000
+	shaCryptRoundsMin     = 1000
+	shaCryptRoundsMax     = 999999999
+)
+
+var (
+	// The byte orders used to encode the digest, each of them is encoded into 4 chars.
+	sha512CryptOrder = shaCryptOrder(21, func(a, b, c, i int) [3]int {
+		return [][3]int{{a, b, c}, {b, c, a}, {c, a, b}}[i%3]
+	})
+	sha256CryptOrder = shaCryptOrder(10, func(a, b, c, i int) [3]int {
+		return [][3]int{{a, b, c}, {c, a, b}, {b, c, a}}[i%3]
+	})
+)
+
+func shaCryptOrder(n int, rotate func(a, b, c, i int) [3]int) [][3]int {
+	order := make([][3]int, n)
+	for i := range order {
+		order[i] = rotate(i, i+n, i+2*n, i)
+	}
+	return order
+}
+
+// shaCrypt hashes the password with the setting, which is either a full hash
+// or its "$id$[rounds=N$]salt" prefix.
+func shaCrypt(setting, password string) (string, error) {
+	var newHash func() hash.Hash
+	switch {
+	case strings.HasPrefix(setting, "$5$"):
+		newHash = sha256.New
+	case strings.HasPrefix(setting, "$6$"):
+		newHash = sha512.New
+	default:
+		return "", errors.New("unknown SHA-crypt id")
+	}
+	prefix := setting[:3]
+	setting = setting[3:]
+
+	rounds := shaCryptRoundsDefault
+	customRounds := false
+	if strings.HasPrefix(setting, "rounds=") {
+		end := strings.IndexByte(setting, '$')
+		if end < 0 {
+			return "", errors.New("invalid SHA-crypt rounds")
+		}
+		r, err := strconv.Atoi(setting[len("rounds="):end])
+		if err != nil {
+			return "", errors.New("invalid SHA-crypt rounds")
+		}
+		if r < shaCryptRoundsMin {
+			r = shaCryptRoundsMin
+		} else if r > shaCryptRoundsMax {
+			r = shaCryptRoundsMax
+		}
+		rounds = r
+		customRounds = true
+		setting = setting[end+1:]
+	}
+
+	salt := setting
+	if i := strings.IndexByte(salt, '$'); i >= 0 {
+		salt = salt[:i]
+	}
+	if len(salt) > shaCryptSaltLen {
+		salt = salt[:shaCryptSaltLen]
+	}
+
+	pw := []byte(password)
+	s := []byte(salt)
+
+	h := newHash()
+	h.Write(pw)
+	h.Write(s)
+	h.Write(pw)
+	b := h.Sum(nil)
+
+	h.Reset()
+	h.Write(pw)
+	h.Write(s)
+	h.Write(repeatBytes(b, len(pw)))
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			h.Write(b)
+		} else {
+			h.Write(pw)
+		}
+	}
+	a := h.Sum(nil)
+
+	h.Reset()
+	for i := 0; i < len(pw); i++ {
+		h.Write(pw)
+	}
+	p := repeatBytes(h.Sum(nil), len(pw))
+
+	h.Reset()
+	for i := 0; i < 16+int(a[0]); i++ {
+		h.Write(s)
+	}
+	ss := repeatBytes(h.Sum(nil), len(s))
+
+	c := a
+	for i := 0; i < rounds; i++ {
+		h.Reset()
+		if i&1 != 0 {
+			h.Write(p)
+		} else {
+			h.Write(c)
+		}
+		if i%3 != 0 {
+			h.Write(ss)
+		}
+		if i%7 != 0 {
+			h.Write(p)
+		}
+		if i&1 != 0 {
+			h.Write(c)
+		} else {
+			h.Write(p)
+		}
+		c = h.Sum(nil)
+	}
+
+	out := new(strings.Builder)
+	out.WriteString(prefix)
+	if customRounds {
+		out.WriteString("rounds=" + strconv.Itoa(rounds) + "$")
+	}
+	out.WriteString(salt)
+	out.WriteByte('$')
+	if len(c) == sha512.Size {
+		for _, o := range sha512CryptOrder {
+			encodeSHACrypt(out, c[o[0]], c[o[1]], c[o[2]], 4)
+		}
+		encodeSHACrypt(out, 0, 0, c[63], 2)
+	} else {
+		for _, o := range sha256CryptOrder {
+			encodeSHACrypt(out, c[o[0]], c[o[1]], c[o[2]], 4)
+		}
+		encodeSHACrypt(out, 0, c[31], c[30], 3)
+	}
+	return out.String(), nil
+}
+
+// repeatBytes repeats b until it is n bytes long.
+func repeatBytes(b []byte, n int) []byte {
+	out := make([]byte, 0, n)
+	for len(out) < n {
+		out = append(out, b...)
+	}
+	return out[:n]
+}
+
+func encodeSHACrypt(out *strings.Builder, b2, b1, b0 byte, n int) {
+	w := uint(b2)<<16 | uint(b1)<<8 | uint(b0)
+	for i := 0; i < n; i++ {
+		out.WriteByte(shaCryptAlphabet[w&0x3f])
+		w >>= 6
+	}
+}
@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"github.com/beyondstorage/beyond-ftp/config"
+)
+
+// UsersAuthenticator authenticates the users in the users table of the config.
+type UsersAuthenticator struct {
+	users map[string]*config.User
+}
+
+// NewUsersAuthenticator creates an authenticator for the users table.
+func NewUsersAuthenticator(users map[string]*config.User) *UsersAuthenticator {
+	return &UsersAuthenticator{users: users}
+}
+
+// LookupUser implements UserLookup.
+func (a *UsersAuthenticator) LookupUser(name string) (*config.User, bool) {
+	u, ok := a.users[name]
+	return u, ok
+}
+
+// Authenticate implements Authenticator.
+func (a *UsersAuthenticator) Authenticate(req *Request) (*Identity, error) {
+	u, ok := a.users[req.User]
+	if ok && u.ClientCert != "" {
+		if !CertificateMatches(req.PeerCertificate(), u.CertNames) {
+			return nil, ErrCertificateMismatch
+		}
+		if u.ClientCert == config.ClientCertSufficient {
+			return &Identity{User: req.User, Settings: u}, nil
+		}
+	}
+
+	if req.WithoutPassword {
+		return nil, ErrPasswordRequired
+	}
+	// A user without password can only log in with the client certificate.
+	if ok && u.Password != "" && ComparePassword(u.Password, req.Password) {
+		return &Identity{User: req.User, Settings: u}, nil
+	}
+	return nil, ErrInvalidCredentials
+}
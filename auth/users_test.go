@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/beyondstorage/beyond-ftp/config"
+)
+
+func TestUsersAuthenticator(t *testing.T) {
+	a := NewUsersAuthenticator(map[string]*config.User{
+		"nopassword": {},
+		"test":       {Password: "test"},
+		"machine":    {ClientCert: config.ClientCertSufficient, CertNames: []string{"machine"}},
+	})
+
+	cases := []struct {
+		req *Request
+		err error
+	}{
+		{&Request{User: "test", WithoutPassword: true}, ErrPasswordRequired},
+		{&Request{User: "unknown", WithoutPassword: true}, ErrPasswordRequired},
+		{&Request{User: "test", Password: "test"}, nil},
+		{&Request{User: "test", Password: "wrong"}, ErrInvalidCredentials},
+		{&Request{User: "unknown", Password: "test"}, ErrInvalidCredentials},
+		{&Request{User: "anonymous", Password: "guest@example.com"}, ErrInvalidCredentials},
+		{&Request{User: "nopassword", Password: ""}, ErrInvalidCredentials},
+		{&Request{User: "machine", WithoutPassword: true}, ErrCertificateMismatch},
+	}
+	for _, c := range cases {
+		identity, err := a.Authenticate(c.req)
+		assert.Equal(t, c.err, err, c.req.User)
+		if err == nil {
+			assert.Equal(t, c.req.User, identity.User)
+		}
+	}
+}
+
+func TestAnonymousAuthenticator(t *testing.T) {
+	a := NewAnonymousAuthenticator(&config.Anonymous{Enabled: true, Home: "/pub"})
+
+	identity, err := a.Authenticate(&Request{User: "anonymous", Password: "guest@example.com"})
+	assert.Nil(t, err)
+	assert.Equal(t, "/pub", identity.Settings.Home)
+	_, err = a.Authenticate(&Request{User: "ftp", Password: ""})
+	assert.Nil(t, err)
+	_, err = a.Authenticate(&Request{User: "ftp", WithoutPassword: true})
+	assert.Equal(t, ErrPasswordRequired, err)
+	_, err = a.Authenticate(&Request{User: "test", Password: "test"})
+	assert.Equal(t, ErrInvalidCredentials, err)
+
+	u, ok := a.LookupUser("anonymous")
+	assert.True(t, ok)
+	assert.False(t, u.Permitted(config.PermissionWrite, "/file"))
+}
@@ -0,0 +1,267 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/beyondstorage/beyond-ftp/config"
+	"github.com/beyondstorage/beyond-ftp/utils"
+)
+
+// maxWebhookCacheSize is the maximum number of cached responses.
+const maxWebhookCacheSize = 1024
+
+// WebhookAuthenticator authenticates the users by POSTing the login requests
+// to a HTTP endpoint.
+//
+// The body of the request is a JSON object:
+//
+//	{
+//	  "user": "partner",
+//	  "password": "secret",          // "password-sha256" instead if hash-password is set
+//	  "remote-addr": "192.0.2.1:50000",
+//	  "tls": {                        // null if the control connection is in clear
+//	    "version": "TLS 1.3",
+//	    "cipher-suite": "TLS_AES_128_GCM_SHA256",
+//	    "server-name": "ftp.example.com",
+//	    "client-certificate": {"subject": "CN=partner", "issuer": "CN=CA", "dns-names": [], "email-addresses": []}
+//	  }
+//	}
+//
+// The response is a JSON object with "allow", an optional "message" which is
+// logged on denial, and the keys of a user in the users table, which are the
+// configuration of the session. The configuration is checked like the users
+// table, an invalid one fails the login. Quotas are not supported, a response
+// allowing the login with a "quota" fails it rather than ignoring the quota.
+type WebhookAuthenticator struct {
+	config *config.Webhook
+	client *http.Client
+	ttl    time.Duration
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]*webhookResult
+}
+
+type webhookRequest struct {
+	User           string      `json:"user"`
+	Password       string      `json:"password,omitempty"`
+	PasswordSHA256 string      `json:"password-sha256,omitempty"`
+	RemoteAddr     string      `json:"remote-addr"`
+	TLS            *webhookTLS `json:"tls"`
+}
+
+type webhookTLS struct {
+	Version           string              `json:"version"`
+	CipherSuite       string              `json:"cipher-suite"`
+	ServerName        string              `json:"server-name,omitempty"`
+	ClientCertificate *webhookCertificate `json:"client-certificate,omitempty"`
+}
+
+type webhookCertificate struct {
+	Subject        string   `json:"subject"`
+	Issuer         string   `json:"issuer"`
+	DNSNames       []string `json:"dns-names"`
+	EmailAddresses []string `json:"email-addresses"`
+}
+
+type webhookResponse struct {
+	Allow   bool            `json:"allow"`
+	Message string          `json:"message"`
+	Quota   json.RawMessage `json:"quota"`
+}
+
+type webhookResult struct {
+	settings *config.User // Configuration of the user, nil if the user is denied
+	message  string
+	expires  time.Time
+}
+
+var tlsVersions = map[uint16]string{
+	tls.VersionTLS10: "TLS 1.0",
+	tls.VersionTLS11: "TLS 1.1",
+	tls.VersionTLS12: "TLS 1.2",
+	tls.VersionTLS13: "TLS 1.3",
+}
+
+// NewWebhookAuthenticator creates an authenticator for the webhook.
+func NewWebhookAuthenticator(c *config.Webhook) *WebhookAuthenticator {
+	return &WebhookAuthenticator{
+		config: c,
+		client: &http.Client{Timeout: time.Duration(c.Timeout) * time.Second},
+		ttl:    time.Duration(c.CacheTTL) * time.Second,
+		cache:  make(map[[sha256.Size]byte]*webhookResult),
+	}
+}
+
+// Authenticate implements Authenticator.
+func (a *WebhookAuthenticator) Authenticate(req *Request) (*Identity, error) {
+	if req.WithoutPassword {
+		return nil, ErrPasswordRequired
+	}
+
+	key := a.cacheKey(req)
+	r := a.cached(key)
+	if r == nil {
+		var err error
+		r, err = a.post(req)
+		if err != nil {
+			return nil, err
+		}
+		a.store(key, r)
+	}
+
+	if r.settings == nil {
+		if r.message != "" {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidCredentials, r.message)
+		}
+		return nil, ErrInvalidCredentials
+	}
+	return &Identity{User: req.User, Settings: r.settings}, nil
+}
+
+func (a *WebhookAuthenticator) post(req *Request) (*webhookResult, error) {
+	body, err := json.Marshal(newWebhookRequest(req, a.config.HashPassword))
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequest(http.MethodPost, a.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	for k, v := range a.config.Headers {
+		httpReq.Header.Set(k, v)
+	}
+
+	resp, err := a.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("webhook: read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("webhook: unexpected status %s", resp.Status)
+	}
+
+	var res webhookResponse
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, fmt.Errorf("webhook: invalid response: %w", err)
+	}
+	r := &webhookResult{message: res.Message}
+	if res.Allow {
+		if len(res.Quota) > 0 && string(res.Quota) != "null" {
+			return nil, errors.New("webhook: invalid response: quota is not supported")
+		}
+		r.settings = &config.User{}
+		if err := json.Unmarshal(data, r.settings); err != nil {
+			return nil, fmt.Errorf("webhook: invalid response: %w", err)
+		}
+		// The password is never used, avoid keeping what the webhook may return.
+		r.settings.Password = ""
+		if err := config.CheckUsers(map[string]*config.User{req.User: r.settings}); err != nil {
+			return nil, fmt.Errorf("webhook: invalid response: %w", err)
+		}
+	}
+	return r, nil
+}
+
+func newWebhookRequest(req *Request, hashPassword bool) *webhookRequest {
+	r := &webhookRequest{
+		User:       req.User,
+		RemoteAddr: req.RemoteAddr,
+	}
+	if hashPassword {
+		sum := sha256.Sum256([]byte(req.Password))
+		r.PasswordSHA256 = hex.EncodeToString(sum[:])
+	} else {
+		r.Password = req.Password
+	}
+
+	if req.TLS != nil {
+		r.TLS = &webhookTLS{
+			Version:     tlsVersions[req.TLS.Version],
+			CipherSuite: tls.CipherSuiteName(req.TLS.CipherSuite),
+			ServerName:  req.TLS.ServerName,
+		}
+		if cert := req.PeerCertificate(); cert != nil {
+			r.TLS.ClientCertificate = &webhookCertificate{
+				Subject:        cert.Subject.String(),
+				Issuer:         cert.Issuer.String(),
+				DNSNames:       cert.DNSNames,
+				EmailAddresses: cert.EmailAddresses,
+			}
+		}
+	}
+	return r
+}
+
+// cacheKey returns the key of the request in the cache, the password is hashed
+// so that it is not kept in memory.
+func (a *WebhookAuthenticator) cacheKey(req *Request) [sha256.Size]byte {
+	host := utils.AddrIP(req.RemoteAddr).String()
+	cert := ""
+	if c := req.PeerCertificate(); c != nil {
+		cert = c.Subject.String()
+	}
+
+	h := sha256.New()
+	for _, s := range []string{req.User, req.Password, host, cert} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	var key [sha256.Size]byte
+	copy(key[:], h.Sum(nil))
+	return key
+}
+
+func (a *WebhookAuthenticator) cached(key [sha256.Size]byte) *webhookResult {
+	if a.ttl <= 0 {
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	r, ok := a.cache[key]
+	if !ok || time.Now().After(r.expires) {
+		return nil
+	}
+	return r
+}
+
+func (a *WebhookAuthenticator) store(key [sha256.Size]byte, r *webhookResult) {
+	if a.ttl <= 0 {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	if len(a.cache) >= maxWebhookCacheSize {
+		for k, v := range a.cache {
+			if now.After(v.expires) {
+				delete(a.cache, k)
+			}
+		}
+		if len(a.cache) >= maxWebhookCacheSize {
+			return
+		}
+	}
+	r.expires = now.Add(a.ttl)
+	a.cache[key] = r
+}
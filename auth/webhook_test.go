@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/beyondstorage/beyond-ftp/config"
+)
+
+func TestWebhookAuthenticator(t *testing.T) {
+	var calls int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+
+		var req webhookRequest
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, "192.0.2.1:50000", req.RemoteAddr)
+		assert.Nil(t, req.TLS)
+
+		switch {
+		case req.User == "partner" && req.Password == "secret":
+			_, _ = w.Write([]byte(`{"allow": true, "require-data-tls": true}`))
+		case req.User == "broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		case req.User == "invalid":
+			_, _ = w.Write([]byte(`{"allow": true, "permissions": ["execute"]}`))
+		case req.User == "quota":
+			_, _ = w.Write([]byte(`{"allow": true, "quota": 1073741824}`))
+		default:
+			_, _ = w.Write([]byte(`{"allow": false, "message": "unknown user"}`))
+		}
+	}))
+	defer s.Close()
+
+	a := NewWebhookAuthenticator(&config.Webhook{
+		URL:      s.URL,
+		Headers:  map[string]string{"Authorization": "Bearer token"},
+		Timeout:  5,
+		CacheTTL: 30,
+	})
+
+	req := &Request{User: "partner", Password: "secret", RemoteAddr: "192.0.2.1:50000"}
+	identity, err := a.Authenticate(req)
+	assert.Nil(t, err)
+	assert.Equal(t, "partner", identity.User)
+	assert.True(t, *identity.Settings.RequireDataTLS)
+
+	// The response is cached, even for another port of the same client.
+	_, err = a.Authenticate(&Request{User: "partner", Password: "secret", RemoteAddr: "192.0.2.1:50001"})
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	_, err = a.Authenticate(&Request{User: "partner", Password: "wrong", RemoteAddr: "192.0.2.1:50000"})
+	assert.True(t, errors.Is(err, ErrInvalidCredentials))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	_, err = a.Authenticate(&Request{User: "broken", Password: "secret", RemoteAddr: "192.0.2.1:50000"})
+	assert.NotNil(t, err)
+	assert.False(t, errors.Is(err, ErrInvalidCredentials))
+
+	// The invalid settings fail the login.
+	_, err = a.Authenticate(&Request{User: "invalid", Password: "secret", RemoteAddr: "192.0.2.1:50000"})
+	assert.EqualError(t, err, `webhook: invalid response: user invalid: invalid permission "execute"`)
+
+	// The quotas would not be enforced, they fail the login.
+	_, err = a.Authenticate(&Request{User: "quota", Password: "secret", RemoteAddr: "192.0.2.1:50000"})
+	assert.EqualError(t, err, "webhook: invalid response: quota is not supported")
+	assert.False(t, errors.Is(err, ErrInvalidCredentials))
+
+	_, err = a.Authenticate(&Request{User: "partner", WithoutPassword: true})
+	assert.Equal(t, ErrPasswordRequired, err)
+}
+
+func TestWebhookAuthenticatorTimeout(t *testing.T) {
+	done := make(chan struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer s.Close()
+	defer close(done)
+
+	a := NewWebhookAuthenticator(&config.Webhook{URL: s.URL, CacheTTL: -1, HashPassword: true})
+	a.client.Timeout = 50 * time.Millisecond
+
+	start := time.Now()
+	_, err := a.Authenticate(&Request{User: "partner", Password: "secret"})
+	assert.NotNil(t, err)
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+}
+
+func TestNewWebhookRequest(t *testing.T) {
+	r := newWebhookRequest(&Request{User: "partner", Password: "secret"}, true)
+	assert.Empty(t, r.Password)
+	assert.Equal(t, "2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b", r.PasswordSHA256)
+}
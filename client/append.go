@@ -0,0 +1,128 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/beyondstorage/go-storage/v4/services"
+	"github.com/beyondstorage/go-storage/v4/types"
+)
+
+// appendChunkSize is the size of the chunks written by WriteAppend.
+const appendChunkSize = 4 << 20
+
+// Handle the "APPE" command. The data is appended natively if the storage is
+// an Appender and the file is appendable, the file is rewritten otherwise.
+func (c *Handler) handleAPPE() {
+	path := c.absPath(c.param)
+	c.ctxRest = 0
+
+	object, err := c.storager.Stat(c.storagePath(path))
+	if err != nil && !errors.Is(err, services.ErrObjectNotExist) {
+		c.WriteMessage(StatusActionNotTaken, fmt.Sprintf("Couldn't access %s: %v", path, err))
+		return
+	}
+	if object != nil && object.GetMode().IsDir() {
+		c.WriteMessage(StatusActionNotTaken, fmt.Sprintf("%s is a directory", path))
+		return
+	}
+	if object != nil && !c.appendable(&fileInfo{object}) {
+		c.WriteMessage(StatusCommandNotImplemented, fmt.Sprintf("The storage can't append to %s", path))
+		return
+	}
+
+	tr, err := c.TransferOpen()
+	if err != nil {
+		c.WriteMessage(StatusCannotOpenDataConnection, err.Error())
+		return
+	}
+
+	if appender, ok := c.storager.(types.Appender); ok && (object == nil || object.GetMode().IsAppend()) {
+		err = c.appendTo(appender, object, c.storagePath(path), tr)
+	} else {
+		err = c.rewrite(c.storagePath(path), object != nil, tr)
+	}
+	if err != nil {
+		c.transferFailed(tr, StatusFileActionNotTaken, err)
+		return
+	}
+
+	select {
+	case <-c.commandAbortCtx.Done():
+		c.WriteMessage(StatusTransferAborted, "Connection closed; transfer aborted")
+	default:
+		c.TransferClose()
+		c.WriteMessage(StatusClosingDataConn, "transfer finished")
+	}
+}
+
+// appendTo appends the data of r to the appendable object at path, it is
+// created if o is nil.
+func (c *Handler) appendTo(appender types.Appender, o *types.Object, path string, r io.Reader) error {
+	ctx := c.commandAbortCtx
+	if o == nil {
+		var err error
+		if o, err = appender.CreateAppendWithContext(ctx, path); err != nil {
+			return err
+		}
+	}
+	offset, ok := o.GetAppendOffset()
+	if !ok {
+		offset, _ = o.GetContentLength()
+	}
+
+	buf := make([]byte, appendChunkSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			written, err := appender.WriteAppendWithContext(ctx, o, bytes.NewReader(buf[:n]), int64(n))
+			if err != nil {
+				return err
+			}
+			offset += written
+			o.SetAppendOffset(offset)
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return appender.CommitAppendWithContext(ctx, o)
+}
+
+// rewrite appends the data of r to the file at path by uploading its content
+// followed by r, for the storages which can't append.
+func (c *Handler) rewrite(path string, exists bool, r io.Reader) error {
+	if exists {
+		pr, pw := io.Pipe()
+		defer pr.Close()
+		go func() {
+			_, err := c.storager.ReadWithContext(c.commandAbortCtx, path, pw)
+			pw.CloseWithError(err)
+		}()
+		r = io.MultiReader(pr, r)
+	}
+	return c.upload(path, r)
+}
+
+// appendable reports whether APPE can append to the existing file.
+func (c *Handler) appendable(f *fileInfo) bool {
+	if _, ok := c.storager.(types.Appender); ok && f.GetMode().IsAppend() {
+		return true
+	}
+	max := c.serverSetting.MaxAppendRewriteSize
+	return max > 0 && f.Size() <= max
+}
+
+// appendFeature returns the APPE line of FEAT, empty if the storage can only
+// append to the new files.
+func (c *Handler) appendFeature() string {
+	if _, ok := c.storager.(types.Appender); ok || c.serverSetting.MaxAppendRewriteSize > 0 {
+		return "APPE"
+	}
+	return ""
+}
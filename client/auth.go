@@ -1,9 +1,39 @@
 package client
 
+import (
+	"errors"
+	"path"
+
+	"go.uber.org/zap"
+
+	"github.com/beyondstorage/beyond-ftp/auth"
+	"github.com/beyondstorage/beyond-ftp/config"
+	"github.com/beyondstorage/beyond-ftp/utils"
+)
+
 // Handle the "USER" command.
 func (c *Handler) handleUSER() {
-	c.user = c.param
-	c.WriteMessage(StatusUserOK, "User name okay, need password.")
+	if !c.controlTLS && c.serverSetting.TLSRequired(c.userConfig(c.param)) {
+		c.WriteMessage(StatusRequestDeniedPolicy, "TLS is required, please use AUTH TLS first")
+		return
+	}
+
+	// The user may be identified without password, by the client certificate for example.
+	req := c.authRequest(c.param)
+	req.WithoutPassword = true
+	identity, err := c.authenticator.Authenticate(req)
+	switch {
+	case err == nil:
+		if c.login(identity) != nil {
+			return
+		}
+		c.WriteMessage(StatusUserLoggedInSecure, "User logged in with client certificate")
+	case errors.Is(err, auth.ErrPasswordRequired):
+		c.user = c.param
+		c.WriteMessage(StatusUserOK, "User name okay, need password.")
+	default:
+		c.loginFailed(c.param, err)
+	}
 }
 
 // Handle the "PASS" command.
@@ -20,13 +50,151 @@ func (c *Handler) handlePASS() {
 	username := c.user
 	password := c.param
 
-	if v, ok := c.serverSetting.Users[username]; ok {
-		if username == "anonymous" || password == v {
-			c.loginUser = username
-			c.WriteMessage(StatusUserLoggedIn, "Password ok, continue")
-			return
+	if !c.controlTLS && c.serverSetting.TLSRequired(c.userConfig(username)) {
+		c.WriteMessage(StatusRequestDeniedPolicy, "TLS is required, please use AUTH TLS first")
+		return
+	}
+
+	req := c.authRequest(username)
+	req.Password = password
+	identity, err := c.authenticator.Authenticate(req)
+	if err != nil {
+		c.loginFailed(username, err)
+		return
+	}
+
+	// The authenticator may provide the configuration of the user only after the login.
+	if !c.controlTLS && c.serverSetting.TLSRequired(identity.Settings) {
+		c.WriteMessage(StatusRequestDeniedPolicy, "TLS is required, please use AUTH TLS first")
+		return
+	}
+
+	if c.login(identity) != nil {
+		return
+	}
+	c.WriteMessage(StatusUserLoggedIn, "Password ok, continue")
+}
+
+// userConfig returns the configuration of the user, nil if the authenticator does not know it.
+func (c *Handler) userConfig(name string) *config.User {
+	if lookup, ok := c.authenticator.(auth.UserLookup); ok {
+		if u, ok := lookup.LookupUser(name); ok {
+			return u
 		}
 	}
+	return nil
+}
 
+func (c *Handler) authRequest(user string) *auth.Request {
+	return &auth.Request{
+		User:       user,
+		RemoteAddr: c.remoteAddr,
+		TLS:        c.tlsState(),
+	}
+}
+
+// login switches the session to the user, it fails if the user is not allowed
+// from the remote address, has too many sessions, or the storage of the user
+// is unavailable.
+func (c *Handler) login(identity *auth.Identity) error {
+	// The settings come from the backend which authenticated the user, never
+	// from a local user of the same name.
+	settings := identity.Settings
+	if !settings.IPAllowed(utils.AddrIP(c.remoteAddr)) {
+		zap.L().Warn("User login refused from the address",
+			zap.String("id", c.id),
+			zap.String("user", identity.User),
+			zap.String("remote address", c.remoteAddr),
+		)
+		c.WriteMessage(StatusNotLoggedIn, "Login not allowed from your address")
+		return errors.New("remote address not allowed")
+	}
+
+	// The session may be switched from another user.
+	c.logout()
+	if c.sessions != nil && !c.sessions.Login(identity.User, settings) {
+		zap.L().Warn("User login refused for too many connections",
+			zap.String("id", c.id),
+			zap.String("user", identity.User),
+			zap.String("remote address", c.remoteAddr),
+		)
+		c.WriteMessage(StatusServiceNotAvailable, "Too many connections")
+		c.disconnect()
+		return errors.New("too many connections")
+	}
+
+	service, home := c.serverSetting.Service, "/"
+	if settings != nil && settings.Service != "" {
+		service = settings.Service
+	}
+	if settings != nil && settings.Home != "" {
+		home = path.Clean("/" + settings.Home)
+	}
+
+	storager, err := c.userStorager(service)
+	if err != nil {
+		zap.L().Error("Cannot create the storager of the user",
+			zap.String("id", c.id),
+			zap.String("user", identity.User),
+			zap.Error(err),
+		)
+		if c.sessions != nil {
+			c.sessions.Logout(identity.User)
+		}
+		c.WriteMessage(StatusNotLoggedIn, "Storage of the user is unavailable")
+		return err
+	}
+
+	c.stopLoginTimer()
+	c.loginUser = identity.User
+	c.userSetting = settings
+	c.storager = storager
+	c.home = home
+	c.path = "/"
+	zap.L().Info("User logged in",
+		zap.String("id", c.id),
+		zap.String("user", identity.User),
+		zap.String("remote address", c.remoteAddr),
+		zap.String("home", c.home),
+	)
+	return nil
+}
+
+// logout unregisters the session of the login user.
+func (c *Handler) logout() {
+	if c.loginUser == "" {
+		return
+	}
+	if c.sessions != nil {
+		c.sessions.Logout(c.loginUser)
+	}
+	c.loginUser = ""
+}
+
+// loginFailed replies the failure, the detail of the error is only logged
+// since it may come from the backend of the authenticator. The connection is
+// closed if the client is banned or fails too many times.
+func (c *Handler) loginFailed(user string, err error) {
+	c.loginFailures++
+	zap.L().Info("User login failed",
+		zap.String("event", "login_failed"),
+		zap.String("id", c.id),
+		zap.String("user", user),
+		zap.String("remote address", c.remoteAddr),
+		zap.Int("attempts", c.loginFailures),
+		zap.Error(err),
+	)
+
+	protection := c.serverSetting.LoginProtection
+	if errors.Is(err, auth.ErrBanned) ||
+		(protection != nil && protection.MaxAttempts > 0 && c.loginFailures >= protection.MaxAttempts) {
+		c.WriteMessage(StatusServiceNotAvailable, "Too many failed login attempts, closing control connection")
+		c.disconnect()
+		return
+	}
+	if errors.Is(err, auth.ErrCertificateMismatch) {
+		c.WriteMessage(StatusNotLoggedIn, "Client certificate does not match the user")
+		return
+	}
 	c.WriteMessage(StatusNotLoggedIn, "Invalid username or password")
 }
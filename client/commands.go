@@ -1,5 +1,9 @@
 package client
 
+import (
+	"github.com/beyondstorage/beyond-ftp/config"
+)
+
 const (
 	USER = "USER"
 	PASS = "PASS"
@@ -60,8 +64,9 @@ const (
 // CommandDescription defines which function should be used and if it should be
 // open to anyone or only logged in users.
 type CommandDescription struct {
-	Open bool           // Open to clients without auth.
-	Fn   func(*Handler) // Function to handle it.
+	Open       bool           // Open to clients without auth.
+	Fn         func(*Handler) // Function to handle it.
+	Permission string         // Permission required on the path in the param, not checked if empty.
 }
 
 var commandsMap map[string]*CommandDescription
@@ -78,9 +83,9 @@ func init() {
 	commandsMap[PASS] = &CommandDescription{Fn: (*Handler).handlePASS, Open: true}
 
 	// TLS handling.
-	commandsMap[AUTH] = nil
-	commandsMap[PROT] = nil
-	commandsMap[PBSZ] = nil
+	commandsMap[AUTH] = &CommandDescription{Fn: (*Handler).handleAUTH, Open: true}
+	commandsMap[PROT] = &CommandDescription{Fn: (*Handler).handlePROT, Open: true}
+	commandsMap[PBSZ] = &CommandDescription{Fn: (*Handler).handlePBSZ, Open: true}
 
 	// Misc.
 	commandsMap[FEAT] = &CommandDescription{Fn: (*Handler).handleFEAT, Open: true}
@@ -90,15 +95,15 @@ func init() {
 	commandsMap[ABOR] = &CommandDescription{Fn: (*Handler).handleABOR}
 
 	// File access.
-	commandsMap[SIZE] = &CommandDescription{Fn: (*Handler).handleSIZE}
-	commandsMap[STAT] = &CommandDescription{Fn: (*Handler).handleSTAT}
-	commandsMap[MDTM] = &CommandDescription{Fn: (*Handler).handleMDTM}
-	commandsMap[RETR] = &CommandDescription{Fn: (*Handler).handleRETR}
-	commandsMap[STOR] = &CommandDescription{Fn: (*Handler).handleSTOR}
-	commandsMap[APPE] = nil
-	commandsMap[DELE] = &CommandDescription{Fn: (*Handler).handleDELE}
-	commandsMap[RNFR] = &CommandDescription{Fn: (*Handler).handleRNFR}
-	commandsMap[RNTO] = &CommandDescription{Fn: (*Handler).handleRNTO}
+	commandsMap[SIZE] = &CommandDescription{Fn: (*Handler).handleSIZE, Permission: config.PermissionList}
+	commandsMap[STAT] = &CommandDescription{Fn: (*Handler).handleSTAT, Permission: config.PermissionList}
+	commandsMap[MDTM] = &CommandDescription{Fn: (*Handler).handleMDTM, Permission: config.PermissionList}
+	commandsMap[RETR] = &CommandDescription{Fn: (*Handler).handleRETR, Permission: config.PermissionRead}
+	commandsMap[STOR] = &CommandDescription{Fn: (*Handler).handleSTOR, Permission: config.PermissionWrite}
+	commandsMap[APPE] = &CommandDescription{Fn: (*Handler).handleAPPE, Permission: config.PermissionWrite}
+	commandsMap[DELE] = &CommandDescription{Fn: (*Handler).handleDELE, Permission: config.PermissionDelete}
+	commandsMap[RNFR] = &CommandDescription{Fn: (*Handler).handleRNFR, Permission: config.PermissionRename}
+	commandsMap[RNTO] = &CommandDescription{Fn: (*Handler).handleRNTO, Permission: config.PermissionRename}
 	commandsMap[ALLO] = &CommandDescription{Fn: (*Handler).handleALLO}
 	commandsMap[REST] = &CommandDescription{Fn: (*Handler).handleREST}
 	commandsMap[SITE] = nil
@@ -107,18 +112,18 @@ func init() {
 	commandsMap[CWD] = &CommandDescription{Fn: (*Handler).handleCWD}
 	commandsMap[PWD] = &CommandDescription{Fn: (*Handler).handlePWD}
 	commandsMap[CDUP] = &CommandDescription{Fn: (*Handler).handleCDUP}
-	commandsMap[NLST] = &CommandDescription{Fn: (*Handler).handleLIST}
-	commandsMap[LIST] = &CommandDescription{Fn: (*Handler).handleLIST}
-	commandsMap[MKD] = &CommandDescription{Fn: (*Handler).handleMKD}
-	commandsMap[RMD] = &CommandDescription{Fn: (*Handler).handleRMD}
+	commandsMap[NLST] = &CommandDescription{Fn: (*Handler).handleNLST, Permission: config.PermissionList}
+	commandsMap[LIST] = &CommandDescription{Fn: (*Handler).handleLIST, Permission: config.PermissionList}
+	commandsMap[MKD] = &CommandDescription{Fn: (*Handler).handleMKD, Permission: config.PermissionMkdir}
+	commandsMap[RMD] = &CommandDescription{Fn: (*Handler).handleRMD, Permission: config.PermissionDelete}
 
 	// XMKD, XRMD, XPWD, XCUP
 	// Implementation note:  Deployed FTP clients still make use of the
 	// deprecated commands and most FTP servers support them as aliases
 	// for the standard commands.
 	// ref: https://tools.ietf.org/html/rfc5797
-	commandsMap[XMKD] = &CommandDescription{Fn: (*Handler).handleMKD}
-	commandsMap[XRMD] = &CommandDescription{Fn: (*Handler).handleRMD}
+	commandsMap[XMKD] = &CommandDescription{Fn: (*Handler).handleMKD, Permission: config.PermissionMkdir}
+	commandsMap[XRMD] = &CommandDescription{Fn: (*Handler).handleRMD, Permission: config.PermissionDelete}
 	commandsMap[XPWD] = &CommandDescription{Fn: (*Handler).handlePWD}
 	commandsMap[XCUP] = &CommandDescription{Fn: (*Handler).handleCWD}
 
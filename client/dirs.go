@@ -3,7 +3,6 @@ package client
 import (
 	"errors"
 	"fmt"
-	"io"
 	"os"
 	"path"
 	"time"
@@ -13,14 +12,19 @@ import (
 	"github.com/beyondstorage/go-storage/v4/types"
 )
 
+// absPath returns the absolute path of p in the virtual tree of the user, ".."
+// never goes above "/".
 func (c *Handler) absPath(p string) string {
-	curPath := c.Path()
-
-	p = path.Clean(p)
 	if path.IsAbs(p) {
-		return p
+		return path.Clean(p)
 	}
-	return path.Join(curPath, p)
+	return path.Join(c.Path(), p)
+}
+
+// storagePath returns the path in the storage of the absolute path in the
+// virtual tree, which is always inside the home of the user.
+func (c *Handler) storagePath(p string) string {
+	return path.Join(c.home, path.Clean("/"+p))
 }
 
 func (c *Handler) handleCWD() {
@@ -30,7 +34,7 @@ func (c *Handler) handleCWD() {
 	}
 
 	p := c.absPath(c.param)
-	_, err := c.getDirInfo(p)
+	_, err := c.getDirInfo(c.storagePath(p))
 	if err != nil {
 		c.WriteMessage(StatusActionNotTaken, fmt.Sprintf("CD issue: %v", err))
 		return
@@ -41,7 +45,7 @@ func (c *Handler) handleCWD() {
 
 func (c *Handler) handleMKD() {
 	p := c.absPath(c.param)
-	_, err := c.getDirInfo(p)
+	_, err := c.getDirInfo(c.storagePath(p))
 	if err == nil {
 		c.WriteMessage(StatusActionNotTaken, fmt.Sprintf("Dir already exists: %s", p))
 		return
@@ -55,7 +59,7 @@ func (c *Handler) handleMKD() {
 		c.WriteMessage(StatusCommandNotImplemented, fmt.Sprintf("This type of storage is not support create dir"))
 		return
 	}
-	if _, err := direr.CreateDir(p); err != nil {
+	if _, err := direr.CreateDir(c.storagePath(p)); err != nil {
 		c.WriteMessage(StatusActionNotTaken, fmt.Sprintf("Could not create %s : %v", p, err))
 		return
 	}
@@ -75,7 +79,7 @@ func (c *Handler) getDirInfo(p string) (*fileInfo, error) {
 
 func (c *Handler) handleRMD() {
 	p := c.absPath(c.param)
-	err := c.storager.DeleteWithContext(c.commandAbortCtx, p)
+	err := c.storager.DeleteWithContext(c.commandAbortCtx, c.storagePath(p))
 	if err != nil {
 		c.WriteMessage(StatusActionNotTaken, fmt.Sprintf("Could not delete dir %s: %v", p, err))
 		return
@@ -98,87 +102,121 @@ func (c *Handler) handlePWD() {
 }
 
 func (c *Handler) handleLIST() {
-	dir := c.absPath(c.param)
+	l, err := c.newLister(c.parseListParam(c.param))
+	if err != nil {
+		c.WriteMessage(StatusFileActionNotTaken, err.Error())
+		return
+	}
+	c.transferListing(l)
+}
 
-	fileInfos, err := c.listFile(dir)
+// Handle the "NLST" command, only the names of the files are sent, relative
+// to the parameter so that they can be used in the following commands.
+func (c *Handler) handleNLST() {
+	r := c.parseListParam(c.param)
+	l, err := c.newLister(r)
+	if errors.Is(err, services.ErrObjectNotExist) {
+		c.WriteMessage(StatusActionNotTaken, fmt.Sprintf("No such file or directory: %s", r.dir))
+		return
+	}
 	if err != nil {
 		c.WriteMessage(StatusFileActionNotTaken, err.Error())
 		return
 	}
+	if !r.long {
+		l.format = nameFormat
+	}
 
+	empty, err := l.top.empty()
+	if err != nil {
+		c.WriteMessage(StatusFileActionNotTaken, err.Error())
+		return
+	}
+	if empty {
+		c.WriteMessage(StatusFileActionNotTaken, "No files found")
+		return
+	}
+	c.transferListing(l)
+}
+
+// transferListing streams the listing on the data connection.
+func (c *Handler) transferListing(l *lister) {
 	tr, err := c.TransferOpen()
 	if err != nil {
 		c.WriteMessage(StatusCannotOpenDataConnection, err.Error())
 		return
 	}
-	c.dirList(tr, fileInfos)
+	l.writeTo(tr)
 
 	select {
 	case <-c.commandAbortCtx.Done():
 		c.WriteMessage(StatusTransferAborted, "Connection closed; transfer aborted")
+		return
+	default:
+	}
+	c.TransferClose()
+	switch {
+	case l.err != nil:
+		c.WriteMessage(StatusLocalError, fmt.Sprintf("Listing aborted after %d entries: %v", l.entries, l.err))
+	case l.truncated:
+		c.WriteMessage(StatusClosingDataConn, fmt.Sprintf("Listing truncated to %d entries", l.entries))
 	default:
-		c.TransferClose()
 		c.WriteMessage(StatusClosingDataConn, "")
 	}
 }
 
-func (c *Handler) listFile(p string) ([]*fileInfo, error) {
-	object, err := c.storager.Stat(p)
-	if err != nil {
-		return nil, err
-	}
+// recentListing is the age under which the entries of the listings show the
+// time of modification instead of the year, as in ls.
+const recentListing = 182 * 24 * time.Hour
 
-	var files []*fileInfo
-	if object.GetMode().IsDir() {
-		iterator, err := c.storager.List(p)
-		if err != nil {
-			return nil, err
+// listLine returns the line of the file in the format of ls -l. The storage
+// has no permission bits, they are the configured ones. The owner and the
+// group are taken from the metadata of the object, the user otherwise.
+func (c *Handler) listLine(file *fileInfo) string {
+	mode, links := c.serverSetting.FileMode, 1
+	if mode == 0 {
+		mode = 0644
+	}
+	if file.GetMode().IsDir() {
+		mode, links = os.ModeDir|c.serverSetting.DirMode, 2
+		if mode == os.ModeDir {
+			mode |= 0755
 		}
+	}
 
-		for {
-			o, err := iterator.Next()
-			if err != nil {
-				if errors.Is(err, types.IterateDone) {
-					break
-				} else {
-					return nil, err
-				}
-			}
-			files = append(files, &fileInfo{o})
+	owner, group := c.loginUser, c.loginUser
+	if metadata, ok := file.GetUserMetadata(); ok {
+		if v := metadata["owner"]; v != "" {
+			owner = v
+		}
+		if v := metadata["group"]; v != "" {
+			group = v
 		}
-	} else {
-		files = append(files, &fileInfo{object})
 	}
 
-	return files, nil
-}
-
-func (c *Handler) dirList(w io.Writer, files []*fileInfo) {
-	for _, file := range files {
-		if _, err := fmt.Fprintf(w, "%s 1 ftp ftp %12d %s %s\r\n",
-			file.Mode(),
-			file.Size(),
-			file.ModTime().Format(" Jan _2 15:04"),
-			file.Name(),
-		); err != nil {
-			return
-		}
+	// The time is unknown for some storages, the epoch is shown instead.
+	modified := file.ModTime()
+	if modified.IsZero() {
+		modified = time.Unix(0, 0)
+	}
+	modified = modified.UTC()
+	layout := "Jan _2 15:04"
+	if now := time.Now(); modified.Before(now.Add(-recentListing)) || modified.After(now.Add(time.Hour)) {
+		layout = "Jan _2  2006"
 	}
+
+	return fmt.Sprintf("%s %4d %-8s %-8s %12d %s %s",
+		mode, links, owner, group, file.Size(), modified.Format(layout), file.Name())
 }
 
 type fileInfo struct {
 	*types.Object
 }
 
-func (f *fileInfo) Mode() os.FileMode {
-	if f.GetMode().IsDir() {
-		return os.ModeDir
-	}
-	return os.ModePerm
-}
-
+// Size returns the size of the file, 0 if it is unknown such as for the directories.
 func (f *fileInfo) Size() int64 {
-	return f.MustGetContentLength()
+	size, _ := f.GetContentLength()
+	return size
 }
 
 func (f *fileInfo) Name() string {
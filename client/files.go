@@ -2,6 +2,7 @@ package client
 
 import (
 	"fmt"
+	"io"
 	"strconv"
 
 	"github.com/beyondstorage/go-storage/v4/pairs"
@@ -20,9 +21,8 @@ func (c *Handler) handleSTOR() {
 		return
 	}
 
-	if err := c.upload(path, tr); err != nil {
-		c.TransferClose()
-		c.WriteMessage(StatusFileActionNotTaken, err.Error())
+	if err := c.upload(c.storagePath(path), tr); err != nil {
+		c.transferFailed(tr, StatusFileActionNotTaken, err)
 		return
 	}
 
@@ -35,8 +35,9 @@ func (c *Handler) handleSTOR() {
 	}
 }
 
-func (c *Handler) upload(path string, tr utils.Conn) error {
+func (c *Handler) upload(path string, tr io.Reader) error {
 	writer := utils.NewStoragerWriter(path, c.storager)
+	defer writer.Close()
 	_, err := writer.ReadFrom(tr)
 	if err != nil {
 		return err
@@ -56,10 +57,9 @@ func (c *Handler) handleRETR() {
 		return
 	}
 
-	_, err = c.storager.ReadWithContext(c.commandAbortCtx, path, tr, pairs.WithOffset(c.ctxRest))
+	_, err = c.storager.ReadWithContext(c.commandAbortCtx, c.storagePath(path), tr, pairs.WithOffset(c.ctxRest))
 	if err != nil {
-		c.TransferClose()
-		c.WriteMessage(StatusActionNotTaken, err.Error())
+		c.transferFailed(tr, StatusActionNotTaken, err)
 		return
 	}
 
@@ -74,7 +74,7 @@ func (c *Handler) handleRETR() {
 
 func (c *Handler) handleDELE() {
 	path := c.absPath(c.param)
-	err := c.storager.DeleteWithContext(c.commandAbortCtx, path)
+	err := c.storager.DeleteWithContext(c.commandAbortCtx, c.storagePath(path))
 	if err != nil {
 		c.WriteMessage(StatusActionNotTaken, fmt.Sprintf("Couldn't delete %s: %v", path, err))
 		return
@@ -84,7 +84,7 @@ func (c *Handler) handleDELE() {
 
 func (c *Handler) handleRNFR() {
 	path := c.absPath(c.param)
-	_, err := c.storager.Stat(path)
+	_, err := c.storager.Stat(c.storagePath(path))
 	if err != nil {
 		c.WriteMessage(StatusActionNotTaken, fmt.Sprintf("Couldn't access %s: %v", path, err))
 		return
@@ -106,7 +106,7 @@ func (c *Handler) handleRNTO() {
 		return
 	}
 
-	err := mover.Move(c.ctxRnfr, path)
+	err := mover.Move(c.storagePath(c.ctxRnfr), c.storagePath(path))
 	if err != nil {
 		c.WriteMessage(StatusActionNotTaken, fmt.Sprintf("Couldn't rename file: %v", err))
 		return
@@ -118,7 +118,7 @@ func (c *Handler) handleRNTO() {
 
 func (c *Handler) handleSIZE() {
 	path := c.absPath(c.param)
-	object, err := c.storager.Stat(path)
+	object, err := c.storager.Stat(c.storagePath(path))
 	if err != nil {
 		c.WriteMessage(StatusActionNotTaken, fmt.Sprintf("Couldn't access %s: %v", path, err))
 		return
@@ -132,15 +132,23 @@ func (c *Handler) handleSIZE() {
 }
 
 func (c *Handler) handleSTATFile() {
-	path := c.absPath(c.param)
-
-	fileInfos, err := c.listFile(path)
+	r := c.parseListParam(c.param)
+	l, err := c.newLister(r)
 	if err != nil {
 		c.WriteMessage(StatusFileActionNotTaken, err.Error())
 		return
 	}
-	c.dirList(c.writer, fileInfos)
-	c.writeLine("213 End of status")
+
+	c.writeLine(fmt.Sprintf("%d- Status of %s:", StatusFileStatus, r.dir))
+	l.writeTo(controlWriter{c})
+	switch {
+	case l.err != nil:
+		c.WriteMessage(StatusFileStatus, fmt.Sprintf("Listing aborted after %d entries: %v", l.entries, l.err))
+	case l.truncated:
+		c.WriteMessage(StatusFileStatus, fmt.Sprintf("Listing truncated to %d entries", l.entries))
+	default:
+		c.WriteMessage(StatusFileStatus, "End of status")
+	}
 }
 
 func (c *Handler) handleALLO() {
@@ -158,7 +166,7 @@ func (c *Handler) handleREST() {
 
 func (c *Handler) handleMDTM() {
 	path := c.absPath(c.param)
-	object, err := c.storager.Stat(path)
+	object, err := c.storager.Stat(c.storagePath(path))
 	if err != nil {
 		c.WriteMessage(StatusActionNotTaken, fmt.Sprintf("Couldn't access %s: %s", path, err.Error()))
 		return
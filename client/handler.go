@@ -3,6 +3,7 @@ package client
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
@@ -10,11 +11,13 @@ import (
 	"runtime/debug"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/beyondstorage/go-storage/v4/types"
 	"go.uber.org/zap"
 
+	"github.com/beyondstorage/beyond-ftp/auth"
 	"github.com/beyondstorage/beyond-ftp/config"
 	"github.com/beyondstorage/beyond-ftp/transfer"
 	"github.com/beyondstorage/beyond-ftp/utils"
@@ -26,10 +29,13 @@ type Handler struct {
 	conn          utils.Conn             // TCP connection
 	writer        *bufio.Writer          // Writer on the TCP connection
 	reader        *bufio.Reader          // Reader on the TCP connection
-	storager      types.Storager         // The root storager
+	storager      types.Storager         // The storager of the session, the root storager until the user logs in
 	user          string                 // Authenticated user
 	loginUser     string                 // login in user name
-	path          string                 // Current path
+	userSetting   *config.User           // Configuration of the login user, nil if the global settings apply
+	loginFailures int                    // Failed logins on the connection
+	path          string                 // Current path, in the virtual tree of the user
+	home          string                 // Home of the login user in the storage, the root of its virtual tree
 	command       string                 // Command received on the connection
 	param         string                 // Param of the FTP command
 	connectedAt   time.Time              // Date of connection
@@ -38,15 +44,30 @@ type Handler struct {
 	ctxRest       int64                  // Restart point
 	transfer      transfer.Handler       // Transfer connection
 	transferTLS   bool                   // Use TLS for transfer connection
+	controlTLS    bool                   // Control connection is protected by TLS
+	tlsConfig     *tls.Config            // TLS config of the server, nil if TLS is disabled
+	authenticator auth.Authenticator     // Authenticator of the login requests
+	sessions      *Sessions              // Sessions of the server, the sessions are not limited if nil
 	serverSetting *config.ServerSettings // serverSetting
 
 	commandArrivedSignalCh chan *CommandDescription
 	commandAbortCtx        context.Context
 	commandAbortCancelFn   context.CancelFunc
 	commandRunningWg       sync.WaitGroup
+	commandRunning         int32 // 1 while a command is running, the control connection is not idle
+	closing                int32 // 1 when the server is shutting down, the session is closed after the running command, 2 once closed
 
-	passiveTransferFactory func(listenHost string, portRange *config.PortRange) (transfer.Handler, int, error)
-	activeTransferFactory  func(*net.TCPAddr) transfer.Handler
+	idleTimer  *time.Timer // Closes the control connection without command
+	loginTimer *time.Timer // Closes the control connection if the user has not logged in
+	writeMu    sync.Mutex  // Serializes the replies of the commands and the timers
+	connMu     sync.Mutex  // Guards conn, which is replaced by AUTH and closed by the timers
+	// 1 once the control connection is closed by closeControl, the reader
+	// goroutine then cleans up the session.
+	controlClosed int32
+
+	userStorager           func(service string) (types.Storager, error)
+	passiveTransferFactory func(listenHost string, portRange *config.PortRange, tlsConfig *tls.Config) (transfer.Handler, int, error)
+	activeTransferFactory  func(addr *net.TCPAddr, tlsConfig *tls.Config) transfer.Handler
 }
 
 // Path provides the current working directory of the client.
@@ -63,15 +84,25 @@ func (c *Handler) SetPath(path string) {
 func (c *Handler) HandleCommands() {
 	ctx, cancelFunc := context.WithCancel(context.Background())
 	go c.handleCommand(ctx)
+	c.startTimers()
+	if c.sessions != nil {
+		c.sessions.add(c)
+	}
 	defer func() {
+		if c.sessions != nil {
+			c.sessions.remove(c)
+		}
+		c.stopTimers()
 		c.TransferClose()
 		cancelFunc()
+		c.logout()
 	}()
 	for {
 		line, err := c.reader.ReadString('\n')
+		c.resetIdleTimer()
 
 		if err != nil {
-			if err == io.EOF {
+			if err == io.EOF || atomic.LoadInt32(&c.controlClosed) == 1 {
 				zap.L().Debug("TCP connect close", zap.String("id", c.id))
 			} else {
 				zap.L().Error("Read error", zap.String("id", c.id), zap.Error(err))
@@ -103,6 +134,12 @@ func (c *Handler) HandleCommands() {
 		switch command {
 		case ABOR:
 			c.handleABOR()
+		case AUTH:
+			// TLS negotiation takes over the connection, so it must not race with the reader.
+			c.commandRunningWg.Wait()
+			c.command = command
+			c.param = param
+			c.handleAUTH()
 		case QUIT:
 			c.commandRunningWg.Wait()
 			c.handleQUIT()
@@ -110,6 +147,7 @@ func (c *Handler) HandleCommands() {
 		default:
 			c.commandRunningWg.Wait()
 			c.commandRunningWg.Add(1)
+			atomic.StoreInt32(&c.commandRunning, 1)
 			c.commandAbortCtx, c.commandAbortCancelFn = context.WithCancel(context.Background())
 			c.command = command
 			c.param = param
@@ -123,15 +161,33 @@ func (c *Handler) TransferOpen() (utils.Conn, error) {
 	if c.transfer == nil {
 		return nil, errors.New("no connection declared")
 	}
+	if !c.transferTLS && c.serverSetting.DataTLSRequired(c.userSetting) {
+		return nil, errors.New("data connection must be protected, please use PROT P first")
+	}
 	c.WriteMessage(StatusFileStatusOK, "Using transfer connection")
 	conn, err := c.transfer.Open()
-	if err == nil {
-		zap.L().Debug("Transfer connection open", zap.String("id", c.id))
-	} else {
+	if err != nil {
 		zap.L().Debug("Transfer connection open failed", zap.String("id", c.id), zap.Error(err))
+		return nil, err
 	}
+	zap.L().Debug("Transfer connection open", zap.String("id", c.id))
 
-	return conn, err
+	if c.serverSetting.DataTimeout > 0 {
+		conn = utils.NewStallConn(conn, time.Duration(c.serverSetting.DataTimeout)*time.Second)
+	}
+	return conn, nil
+}
+
+// transferFailed closes the transfer and replies the failure, or the stall
+// of the data connection which caused it.
+func (c *Handler) transferFailed(tr utils.Conn, code int, err error) {
+	c.TransferClose()
+	if s, ok := tr.(*utils.StallConn); ok && s.Stalled() {
+		zap.L().Info("Data connection stalled", zap.String("id", c.id), zap.String("command", c.command))
+		c.WriteMessage(StatusTransferAborted, "Data connection stalled; transfer aborted")
+		return
+	}
+	c.WriteMessage(code, err.Error())
 }
 
 // TransferClose closes transfer with handler
@@ -155,7 +211,12 @@ func (c *Handler) handleCommand(ctx context.Context) {
 	for {
 		select {
 		case cmdDesc := <-c.commandArrivedSignalCh:
-			cmdDesc.Fn(c)
+			if c.permitted(cmdDesc) {
+				cmdDesc.Fn(c)
+			}
+			atomic.StoreInt32(&c.commandRunning, 0)
+			c.resetIdleTimer()
+			c.closeForShutdown()
 			c.commandRunningWg.Done()
 		case <-ctx.Done():
 			return
@@ -163,20 +224,77 @@ func (c *Handler) handleCommand(ctx context.Context) {
 	}
 }
 
+// permitted checks whether the user has the permission required by the
+// command on the path in its param, and replies if it is denied.
+func (c *Handler) permitted(cmdDesc *CommandDescription) bool {
+	// STAT without param is the status of the server.
+	if cmdDesc.Permission == "" || (c.command == STAT && c.param == "") {
+		return true
+	}
+
+	p := c.absPath(c.param)
+	switch c.command {
+	case LIST, NLST, STAT:
+		// The options and the pattern are not part of the path.
+		p = c.parseListParam(c.param).dir
+	}
+	if c.userSetting.Permitted(cmdDesc.Permission, p) {
+		return true
+	}
+	zap.L().Info("Permission denied",
+		zap.String("id", c.id),
+		zap.String("user", c.loginUser),
+		zap.String("command", c.command),
+		zap.String("path", p),
+	)
+	c.WriteMessage(StatusActionNotTaken, "Permission denied")
+	return false
+}
+
 // WriteMessage writes server response
 func (c *Handler) WriteMessage(code int, message string) {
 	c.writeLine(fmt.Sprintf("%d %s", code, message))
 }
 
-func (c *Handler) disconnect() {
-	if c.transfer != nil {
-		c.transfer.Close()
-		c.transfer = nil
+// shutdown closes the session if it is idle, or marks it to be closed after
+// the running command.
+func (c *Handler) shutdown() {
+	atomic.StoreInt32(&c.closing, 1)
+	if atomic.LoadInt32(&c.commandRunning) == 0 {
+		c.closeForShutdown()
+	}
+}
+
+// closeForShutdown runs on the shutdown goroutine for the idle sessions, and on
+// the command goroutine after a command.
+func (c *Handler) closeForShutdown() {
+	if !atomic.CompareAndSwapInt32(&c.closing, 1, 2) {
+		return
 	}
+	c.WriteMessage(StatusServiceNotAvailable, "Server is shutting down, closing control connection")
+	c.closeControl()
+}
+
+// closeControl closes the control connection from any goroutine. The reader
+// goroutine fails to read then, and cleans up the session itself.
+func (c *Handler) closeControl() {
+	atomic.StoreInt32(&c.controlClosed, 1)
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
 	c.conn.Close()
 }
 
+// disconnect closes the transfer and the control connection, the reader
+// goroutine then ends the session without reporting a read error.
+func (c *Handler) disconnect() {
+	c.TransferClose()
+	c.closeControl()
+}
+
 func (c *Handler) writeLine(line string) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
 	zap.L().Debug("FTP response", zap.String("id", c.id), zap.String("response", line))
 	c.writer.Write([]byte(line))
 	c.writer.Write([]byte("\r\n"))
@@ -186,8 +304,12 @@ func (c *Handler) writeLine(line string) {
 // NewHandler initializes a client handler when someone connects.
 func NewHandler(id, remoteAddr string, connection utils.Conn, settings *config.ServerSettings,
 	storager types.Storager,
-	passive func(string, *config.PortRange) (transfer.Handler, int, error),
-	active func(*net.TCPAddr) transfer.Handler,
+	userStorager func(string) (types.Storager, error),
+	passive func(string, *config.PortRange, *tls.Config) (transfer.Handler, int, error),
+	active func(*net.TCPAddr, *tls.Config) transfer.Handler,
+	tlsConfig *tls.Config,
+	authenticator auth.Authenticator,
+	sessions *Sessions,
 ) *Handler {
 	p := &Handler{
 		id:                     id,
@@ -198,12 +320,23 @@ func NewHandler(id, remoteAddr string, connection utils.Conn, settings *config.S
 		connectedAt:            time.Now().UTC(),
 		remoteAddr:             remoteAddr,
 		path:                   "/",
+		home:                   "/",
 		serverSetting:          settings,
+		tlsConfig:              tlsConfig,
+		authenticator:          authenticator,
+		sessions:               sessions,
 		commandArrivedSignalCh: make(chan *CommandDescription),
 		commandRunningWg:       sync.WaitGroup{},
+		userStorager:           userStorager,
 		passiveTransferFactory: passive,
 		activeTransferFactory:  active,
 	}
 
+	// For implicit FTPS, both the control and the data connections are protected from the beginning.
+	if _, ok := connection.(*tls.Conn); ok {
+		p.controlTLS = true
+		p.transferTLS = true
+	}
+
 	return p
 }
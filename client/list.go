@@ -0,0 +1,338 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/beyondstorage/go-storage/v4/types"
+	"go.uber.org/zap"
+
+	"github.com/beyondstorage/beyond-ftp/config"
+)
+
+// listOptions are the options of ls accepted by LIST, NLST and STAT.
+type listOptions struct {
+	all       bool // -a, list the hidden files
+	long      bool // -l, list in the long format, NLST lists the names otherwise
+	recursive bool // -R, list the subdirectories
+	byTime    bool // -t, sort by modification time, newest first
+	bySize    bool // -S, sort by size, largest first
+}
+
+// listRequest is the parameter of LIST, NLST and STAT, such as "-la dir/*.csv".
+type listRequest struct {
+	listOptions
+	param   string // Path as given by the client, without the pattern
+	dir     string // Absolute path of the listed file or directory in the virtual tree
+	pattern string // Shell pattern the names of the entries must match, any name if empty
+}
+
+// parseListParam parses the parameter of a listing command. The leading words
+// starting with "-" are options, the unknown ones are ignored. The pattern can
+// only be in the last element of the path.
+func (c *Handler) parseListParam(param string) *listRequest {
+	r := &listRequest{}
+	for strings.HasPrefix(param, "-") {
+		word := param
+		param = ""
+		if i := strings.IndexByte(word, ' '); i >= 0 {
+			word, param = word[:i], strings.TrimLeft(word[i:], " ")
+		}
+		for _, o := range word[1:] {
+			switch o {
+			case 'a':
+				r.all = true
+			case 'l':
+				r.long = true
+			case 'R':
+				r.recursive = true
+			case 't':
+				r.byTime = true
+			case 'S':
+				r.bySize = true
+			}
+		}
+	}
+
+	if base := path.Base(param); param != "" && strings.ContainsAny(base, "*?[") {
+		r.pattern = base
+		param = strings.TrimSuffix(param, base)
+		if param != "/" {
+			param = strings.TrimSuffix(param, "/")
+		}
+	}
+	r.param = param
+	r.dir = c.absPath(param)
+	return r
+}
+
+// entries iterates the entries of a directory matching a listing request.
+// They are streamed from the storage, unless they are sorted.
+type entries struct {
+	c       *Handler
+	r       *listRequest
+	pattern string
+
+	it     *types.ObjectIterator
+	sorted []*fileInfo // Remaining entries if they are sorted, nil otherwise
+	peeked *fileInfo
+	done   bool
+}
+
+// dirEntries returns the entries of the directory at dir in the virtual tree
+// matching the request and the pattern. The sorted entries are read at once,
+// up to MaxListEntries.
+func (c *Handler) dirEntries(dir string, r *listRequest, pattern string) (*entries, error) {
+	it, err := c.storager.ListWithContext(c.commandAbortCtx, c.storagePath(dir))
+	if err != nil {
+		return nil, err
+	}
+	e := &entries{c: c, r: r, pattern: pattern, it: it}
+	if !r.byTime && !r.bySize {
+		return e, nil
+	}
+
+	files := []*fileInfo{}
+	max := c.serverSetting.MaxListEntries
+	for max <= 0 || len(files) <= max {
+		f, err := e.read()
+		if err != nil {
+			return nil, err
+		}
+		if f == nil {
+			break
+		}
+		files = append(files, f)
+	}
+	if r.byTime {
+		sort.SliceStable(files, func(i, j int) bool {
+			return files[i].ModTime().After(files[j].ModTime())
+		})
+	} else {
+		sort.SliceStable(files, func(i, j int) bool {
+			si, _ := files[i].GetContentLength()
+			sj, _ := files[j].GetContentLength()
+			return si > sj
+		})
+	}
+	e.sorted = files
+	return e, nil
+}
+
+// fileEntries returns the entries of a listing of a single file.
+func fileEntries(f *fileInfo) *entries {
+	return &entries{sorted: []*fileInfo{f}}
+}
+
+// next returns the next entry, or nil after the last one.
+func (e *entries) next() (*fileInfo, error) {
+	if f := e.peeked; f != nil {
+		e.peeked = nil
+		return f, nil
+	}
+	if e.sorted != nil {
+		if len(e.sorted) == 0 {
+			return nil, nil
+		}
+		f := e.sorted[0]
+		e.sorted = e.sorted[1:]
+		return f, nil
+	}
+	return e.read()
+}
+
+// empty returns whether there is no entry, without consuming any.
+func (e *entries) empty() (bool, error) {
+	if e.peeked == nil {
+		f, err := e.next()
+		if err != nil {
+			return false, err
+		}
+		e.peeked = f
+	}
+	return e.peeked == nil, nil
+}
+
+// read returns the next entry from the storage matching the request, or nil
+// after the last one. The iteration stops when the command is aborted.
+func (e *entries) read() (*fileInfo, error) {
+	for !e.done {
+		if err := e.c.commandAbortCtx.Err(); err != nil {
+			return nil, err
+		}
+		o, err := e.it.Next()
+		if errors.Is(err, types.IterateDone) {
+			e.done = true
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		f := &fileInfo{o}
+		name := f.Name()
+		if !e.r.all && strings.HasPrefix(name, ".") {
+			continue
+		}
+		if e.pattern != "" {
+			if ok, _ := path.Match(e.pattern, name); !ok {
+				continue
+			}
+		}
+		return f, nil
+	}
+	return nil, nil
+}
+
+// listFormat is the format of the entries of a listing.
+type listFormat int
+
+const (
+	longFormat listFormat = iota // Lines of ls -l, with headers for the subdirectories
+	nameFormat                   // Names relative to the parameter
+)
+
+// lister writes the entries of a listing, up to MaxListEntries.
+type lister struct {
+	c      *Handler
+	r      *listRequest
+	format listFormat
+
+	top   *entries // Entries of the listed directory, or the listed file
+	isDir bool     // The listed path is a directory
+
+	w         io.Writer
+	entries   int  // Number of the written entries
+	truncated bool // The listing reached MaxListEntries
+	err       error
+}
+
+// newLister finds the listed path of the request, the entries are written in
+// the long format unless the format is changed before writing.
+func (c *Handler) newLister(r *listRequest) (*lister, error) {
+	info, err := c.getFileInfo(c.storagePath(r.dir))
+	if err != nil {
+		return nil, err
+	}
+
+	l := &lister{c: c, r: r, isDir: info.GetMode().IsDir()}
+	switch {
+	case l.isDir:
+		if l.top, err = c.dirEntries(r.dir, r, r.pattern); err != nil {
+			return nil, err
+		}
+	case r.pattern == "":
+		l.top = fileEntries(info)
+	default:
+		l.top = &entries{sorted: []*fileInfo{}}
+	}
+	return l, nil
+}
+
+// writeTo writes the listing to w, the error of the storage or of w is kept in l.err.
+func (l *lister) writeTo(w io.Writer) {
+	l.w = w
+	if l.isDir {
+		l.writeDir(l.r.dir, l.r.param, l.top, 0)
+		return
+	}
+	f, err := l.top.next()
+	if err != nil {
+		l.err = err
+	} else if f != nil {
+		// A file listed by itself is named as in the parameter.
+		l.writeEntry(f, l.r.param)
+	}
+}
+
+// writeDir writes the entries of the directory at dir in the virtual tree,
+// prefix is its path as given by the client. The subdirectories allowed to be
+// listed are written after them if the listing is recursive.
+func (l *lister) writeDir(dir, prefix string, e *entries, depth int) {
+	settings := l.c.serverSetting
+	if l.r.recursive && l.format == longFormat {
+		header := prefix
+		if header == "" {
+			header = "."
+		}
+		if depth > 0 {
+			header = "\r\n" + header
+		}
+		l.write(header + ":")
+	}
+
+	var subdirs []string
+	for l.err == nil {
+		f, err := e.next()
+		if err != nil {
+			l.err = err
+			return
+		}
+		if f == nil {
+			break
+		}
+		if max := settings.MaxListEntries; max > 0 && l.entries >= max {
+			l.truncated = true
+			return
+		}
+		l.writeEntry(f, path.Join(prefix, f.Name()))
+		if l.r.recursive && f.GetMode().IsDir() {
+			subdirs = append(subdirs, f.Name())
+		}
+	}
+
+	if settings.MaxListDepth > 0 && depth >= settings.MaxListDepth {
+		return
+	}
+	for _, name := range subdirs {
+		sub := path.Join(dir, name)
+		if !l.c.userSetting.Permitted(config.PermissionList, sub) {
+			continue
+		}
+		subEntries, err := l.c.dirEntries(sub, l.r, "")
+		if err != nil {
+			zap.L().Warn("Could not list a subdirectory",
+				zap.String("id", l.c.id),
+				zap.String("path", sub),
+				zap.Error(err),
+			)
+			continue
+		}
+		l.writeDir(sub, path.Join(prefix, name), subEntries, depth+1)
+		if l.truncated || l.err != nil {
+			return
+		}
+	}
+}
+
+// writeEntry writes the file named name relative to the parameter.
+func (l *lister) writeEntry(f *fileInfo, name string) {
+	switch l.format {
+	case longFormat:
+		l.write(l.c.listLine(f))
+	case nameFormat:
+		l.write(name)
+	}
+	l.entries++
+}
+
+func (l *lister) write(line string) {
+	if l.err != nil {
+		return
+	}
+	_, l.err = fmt.Fprintf(l.w, "%s\r\n", line)
+}
+
+// controlWriter writes the lines of a listing on the control connection.
+type controlWriter struct {
+	c *Handler
+}
+
+func (w controlWriter) Write(p []byte) (int, error) {
+	w.c.writeLine(strings.TrimSuffix(string(p), "\r\n"))
+	return len(p), nil
+}
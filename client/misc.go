@@ -55,6 +55,12 @@ func (c *Handler) handleFEAT() {
 		"MDTM",
 		"REST STREAM",
 	}
+	if f := c.appendFeature(); f != "" {
+		features = append(features, f)
+	}
+	if c.tlsConfig != nil {
+		features = append(features, "AUTH TLS", "PBSZ", "PROT")
+	}
 
 	for _, f := range features {
 		c.writeLine(" " + f)
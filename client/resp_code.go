@@ -19,6 +19,7 @@ const (
 	StatusEnteringPASV       = 227 // RFC 959, 4.2.1
 	StatusEnteringEPSV       = 229 // RFC 2428, 3
 	StatusUserLoggedIn       = 230 // RFC 959, 4.2.1
+	StatusUserLoggedInSecure = 232 // RFC 2228, 3
 	StatusAuthAccepted       = 234 // RFC 2228, 3
 	StatusFileOK             = 250 // RFC 959, 4.2.1
 	StatusPathCreated        = 257 // RFC 959, 4.2.1
@@ -33,7 +34,9 @@ const (
 	StatusServiceNotAvailable      = 421 // RFC 959, 4.2.1
 	StatusCannotOpenDataConnection = 425 // RFC 959, 4.2.1
 	StatusTransferAborted          = 426 // RFC 959, 4.2.1
+	StatusNeedUnavailableResource  = 431 // RFC 2228, 3
 	StatusFileActionNotTaken       = 450 // RFC 959, 4.2.1
+	StatusLocalError               = 451 // RFC 959, 4.2.1
 
 	// 500 Series - Syntax error, command unrecognized and the requested action did not take
 	// place. This may include errors such as command line too long.
@@ -43,6 +46,8 @@ const (
 	StatusBadCommandSequence       = 503 // RFC 959, 4.2.1
 	StatusNotImplementedParam      = 504 // RFC 959, 4.2.1
 	StatusNotLoggedIn              = 530 // RFC 959, 4.2.1
+	StatusRequestDeniedPolicy      = 534 // RFC 2228, 3
+	StatusProtLevelNotSupported    = 536 // RFC 2228, 3
 	StatusActionNotTaken           = 550 // RFC 959, 4.2.1
 	StatusActionAborted            = 552 // RFC 959, 4.2.1
 	StatusActionNotTakenNoFile     = 553 // RFC 959, 4.2.1
@@ -0,0 +1,170 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/beyondstorage/beyond-ftp/config"
+	"github.com/beyondstorage/beyond-ftp/utils"
+)
+
+// shutdownInterval is the interval to check whether the sessions are closed during the shutdown.
+const shutdownInterval = 100 * time.Millisecond
+
+// Sessions counts the sessions of a server, and limits them according to the
+// current settings of the server.
+type Sessions struct {
+	settings func() *config.ServerSettings
+
+	mu       sync.Mutex
+	count    int
+	ips      map[string]int
+	users    map[string]int
+	handlers map[*Handler]struct{} // Handlers serving the commands
+	closing  bool                  // The server is shutting down
+}
+
+// NewSessions creates the sessions of the server, settings returns the
+// current settings of the server.
+func NewSessions(settings func() *config.ServerSettings) *Sessions {
+	return &Sessions{
+		settings: settings,
+		ips:      make(map[string]int),
+		users:    make(map[string]int),
+		handlers: make(map[*Handler]struct{}),
+	}
+}
+
+// Open registers a session from the remote address, it returns false if the
+// maximum number of sessions or of sessions from the IP is reached.
+func (s *Sessions) Open(remoteAddr string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ip := ipKey(remoteAddr)
+	settings := s.settings()
+	if max := settings.MaxConnections; max > 0 && s.count >= max {
+		return false
+	}
+	if max := settings.MaxConnectionsPerIP; max > 0 && s.ips[ip] >= max {
+		return false
+	}
+	s.count++
+	s.ips[ip]++
+	return true
+}
+
+// Close unregisters a session opened from the remote address.
+func (s *Sessions) Close(remoteAddr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ip := ipKey(remoteAddr)
+	s.count--
+	if s.ips[ip]--; s.ips[ip] <= 0 {
+		delete(s.ips, ip)
+	}
+}
+
+// Login registers a session of the user, it returns false if the maximum
+// number of sessions of the user is reached. u is nil if the configuration of
+// the user is unknown.
+func (s *Sessions) Login(user string, u *config.User) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	max := s.settings().MaxConnectionsPerUser
+	if u != nil && u.MaxConnections != 0 {
+		max = u.MaxConnections
+	}
+	if max > 0 && s.users[user] >= max {
+		return false
+	}
+	s.users[user]++
+	return true
+}
+
+// Logout unregisters a session of the user.
+func (s *Sessions) Logout(user string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.users[user]--; s.users[user] <= 0 {
+		delete(s.users, user)
+	}
+}
+
+// Shutdown closes the sessions gracefully. The idle sessions are closed at
+// once, and the others when their commands complete. The control connections
+// of the remaining sessions are closed when the context is done, and its error
+// is returned. Each session is cleaned up by its own reader goroutine.
+func (s *Sessions) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closing = true
+	handlers := make([]*Handler, 0, len(s.handlers))
+	for h := range s.handlers {
+		handlers = append(handlers, h)
+	}
+	s.mu.Unlock()
+
+	for _, h := range handlers {
+		h.shutdown()
+	}
+
+	ticker := time.NewTicker(shutdownInterval)
+	defer ticker.Stop()
+	for {
+		s.mu.Lock()
+		remaining := len(s.handlers)
+		s.mu.Unlock()
+		if remaining == 0 {
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			s.mu.Lock()
+			for h := range s.handlers {
+				h.closeControl()
+			}
+			s.mu.Unlock()
+			return ctx.Err()
+		}
+	}
+}
+
+// add registers the handler of a session, it is shut down at once if the
+// server is shutting down.
+func (s *Sessions) add(h *Handler) {
+	s.mu.Lock()
+	s.handlers[h] = struct{}{}
+	closing := s.closing
+	s.mu.Unlock()
+
+	if closing {
+		h.shutdown()
+	}
+}
+
+func (s *Sessions) remove(h *Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.handlers, h)
+}
+
+// Count returns the number of the sessions.
+func (s *Sessions) Count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.count
+}
+
+// ipKey returns the IP of the remote address, or the address itself if it has no IP.
+func ipKey(remoteAddr string) string {
+	if ip := utils.AddrIP(remoteAddr); ip != nil {
+		return ip.String()
+	}
+	return remoteAddr
+}
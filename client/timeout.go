@@ -0,0 +1,62 @@
+package client
+
+import (
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// startTimers starts the idle timer and the login timer of the control connection.
+func (c *Handler) startTimers() {
+	if c.serverSetting.IdleTimeout > 0 {
+		c.idleTimer = time.AfterFunc(c.idleTimeout(), c.idleExpired)
+	}
+	if c.serverSetting.LoginTimeout > 0 {
+		c.loginTimer = time.AfterFunc(time.Duration(c.serverSetting.LoginTimeout)*time.Second, c.loginExpired)
+	}
+}
+
+func (c *Handler) stopTimers() {
+	if c.idleTimer != nil {
+		c.idleTimer.Stop()
+	}
+	c.stopLoginTimer()
+}
+
+func (c *Handler) stopLoginTimer() {
+	if c.loginTimer != nil {
+		c.loginTimer.Stop()
+	}
+}
+
+func (c *Handler) idleTimeout() time.Duration {
+	return time.Duration(c.serverSetting.IdleTimeout) * time.Second
+}
+
+// resetIdleTimer restarts the idle timer on the activity of the control connection.
+func (c *Handler) resetIdleTimer() {
+	if c.idleTimer != nil {
+		c.idleTimer.Reset(c.idleTimeout())
+	}
+}
+
+// idleExpired runs on the goroutine of the timer, it only replies and closes
+// the control connection, which is cleaned up by the reader goroutine.
+func (c *Handler) idleExpired() {
+	// The control connection is silent during the transfers.
+	if atomic.LoadInt32(&c.commandRunning) == 1 {
+		c.resetIdleTimer()
+		return
+	}
+	zap.L().Info("Control connection idle timeout", zap.String("id", c.id))
+	c.WriteMessage(StatusServiceNotAvailable, "Idle timeout, closing control connection")
+	c.closeControl()
+}
+
+// loginExpired runs on the goroutine of the timer, as idleExpired.
+func (c *Handler) loginExpired() {
+	zap.L().Info("Login timeout", zap.String("id", c.id), zap.String("remote address", c.remoteAddr))
+	c.WriteMessage(StatusServiceNotAvailable, "Login timeout, closing control connection")
+	c.closeControl()
+}
@@ -0,0 +1,124 @@
+package client
+
+import (
+	"crypto/tls"
+	"net"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/beyondstorage/beyond-ftp/auth"
+)
+
+// Handle the "AUTH" command, it upgrades the control connection to TLS in place.
+func (c *Handler) handleAUTH() {
+	if c.tlsConfig == nil {
+		c.WriteMessage(StatusNeedUnavailableResource, "TLS is not configured")
+		return
+	}
+
+	switch strings.ToUpper(c.param) {
+	case "TLS", "TLS-C", "SSL":
+	default:
+		c.WriteMessage(StatusNotImplementedParam, "Unsupported AUTH mechanism")
+		return
+	}
+
+	if c.controlTLS {
+		c.WriteMessage(StatusBadCommandSequence, "TLS is already in use")
+		return
+	}
+
+	conn, ok := c.conn.(net.Conn)
+	if !ok {
+		c.WriteMessage(StatusNeedUnavailableResource, "Connection does not support TLS")
+		return
+	}
+
+	c.WriteMessage(StatusAuthAccepted, "AUTH command OK, expecting TLS negotiation")
+
+	tlsConn := tls.Server(conn, c.tlsConfig)
+	_ = conn.SetDeadline(time.Now().Add(time.Minute))
+	if err := tlsConn.Handshake(); err != nil {
+		zap.L().Info("TLS handshake failed", zap.String("id", c.id), zap.Error(err))
+		c.conn.Close()
+		return
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	c.connMu.Lock()
+	c.conn = tlsConn
+	c.connMu.Unlock()
+	c.reader.Reset(tlsConn)
+	c.writeMu.Lock()
+	c.writer.Reset(tlsConn)
+	c.writeMu.Unlock()
+	c.controlTLS = true
+	zap.L().Debug("Control connection upgraded to TLS", zap.String("id", c.id))
+	if cert := auth.PeerCertificate(c.tlsState()); cert != nil {
+		zap.L().Info("TLS client certificate verified",
+			zap.String("id", c.id),
+			zap.String("subject", cert.Subject.String()),
+		)
+	}
+}
+
+// Handle the "PBSZ" command, only "0" makes sense for TLS.
+func (c *Handler) handlePBSZ() {
+	if !c.controlTLS {
+		c.WriteMessage(StatusBadCommandSequence, "AUTH is expected before PBSZ")
+		return
+	}
+	c.WriteMessage(StatusOK, "PBSZ=0")
+}
+
+// Handle the "PROT" command, it selects whether the data connection is protected.
+func (c *Handler) handlePROT() {
+	if !c.controlTLS {
+		c.WriteMessage(StatusBadCommandSequence, "AUTH is expected before PROT")
+		return
+	}
+
+	switch strings.ToUpper(c.param) {
+	case "P":
+		c.setTransferTLS(true)
+		c.WriteMessage(StatusOK, "Data protection level set to private")
+	case "C":
+		c.setTransferTLS(false)
+		c.WriteMessage(StatusOK, "Data protection level set to clear")
+	case "S", "E":
+		c.WriteMessage(StatusProtLevelNotSupported, "Data protection level not supported")
+	default:
+		c.WriteMessage(StatusNotImplementedParam, "Unknown data protection level")
+	}
+}
+
+// setTransferTLS sets the protection of the data connections. PASV and PORT
+// choose the protection of the connection they declare, so a declared
+// connection is closed when the protection changes.
+func (c *Handler) setTransferTLS(enabled bool) {
+	if c.transferTLS != enabled {
+		c.TransferClose()
+	}
+	c.transferTLS = enabled
+}
+
+// transferTLSConfig returns the TLS config used by the data connection, nil
+// means the data connection is in clear.
+func (c *Handler) transferTLSConfig() *tls.Config {
+	if c.transferTLS {
+		return c.tlsConfig
+	}
+	return nil
+}
+
+// tlsState returns the TLS state of the control connection, nil if it is in clear.
+func (c *Handler) tlsState() *tls.ConnectionState {
+	conn, ok := c.conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	state := conn.ConnectionState()
+	return &state
+}
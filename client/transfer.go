@@ -4,11 +4,13 @@ import (
 	"fmt"
 	"strings"
 
+	"go.uber.org/zap"
+
 	"github.com/beyondstorage/beyond-ftp/utils"
 )
 
 func (c *Handler) handlePASV() {
-	p, port, err := c.passiveTransferFactory(c.serverSetting.ListenHost, c.serverSetting.DataPortRange)
+	p, port, err := c.passiveTransferFactory(c.serverSetting.ListenHost, c.serverSetting.DataPortRange, c.transferTLSConfig())
 	if err != nil {
 		c.WriteMessage(StatusCannotOpenDataConnection, "Can't open data connection.")
 		return
@@ -32,6 +34,20 @@ func (c *Handler) handlePASV() {
 
 func (c *Handler) handlePORT() {
 	addr := utils.ParseRemoteAddr(c.param)
-	c.transfer = c.activeTransferFactory(addr)
+	if addr == nil {
+		c.WriteMessage(StatusSyntaxErrorParameters, "Invalid PORT parameter")
+		return
+	}
+	// The server must not be used to connect to the hosts the client is not allowed to reach.
+	if !c.serverSetting.IPAllowed(addr.IP) || !c.userSetting.IPAllowed(addr.IP) {
+		zap.L().Warn("PORT target refused",
+			zap.String("id", c.id),
+			zap.String("user", c.loginUser),
+			zap.String("target", addr.String()),
+		)
+		c.WriteMessage(StatusNotImplementedParam, "PORT target not allowed")
+		return
+	}
+	c.transfer = c.activeTransferFactory(addr, c.transferTLSConfig())
 	c.WriteMessage(StatusOK, "PORT command successful")
 }
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/beyondstorage/beyond-ftp/auth"
+)
+
+var passwdAlgorithmFlag string
+
+// passwdCmd prints the hash of a password, which could be used in the users table.
+var passwdCmd = &cobra.Command{
+	Use:   "passwd [password]",
+	Short: "Print the hash of a password for the users table.",
+	Long: "Print the hash of a password for the users table. " +
+		"The password is read from the standard input if it is not given as an argument.",
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var password string
+		if len(args) == 1 {
+			password = args[0]
+		} else {
+			line, err := bufio.NewReader(cmd.InOrStdin()).ReadString('\n')
+			if err != nil && line == "" {
+				return errors.New("no password is given")
+			}
+			password = strings.TrimRight(line, "\r\n")
+		}
+
+		hash, err := auth.HashPassword(password, passwdAlgorithmFlag)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(cmd.OutOrStdout(), hash)
+		return err
+	},
+}
+
+func init() {
+	passwdCmd.Flags().StringVarP(&passwdAlgorithmFlag, "algorithm", "a", auth.AlgorithmBcrypt,
+		"Hash algorithm, one of bcrypt, argon2id, sha512-crypt and sha256-crypt")
+	rootCmd.AddCommand(passwdCmd)
+}
@@ -1,14 +1,17 @@
 package cmd
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"os/signal"
 	"strings"
-	"sync/atomic"
 	"syscall"
+	"time"
 
 	uuid "github.com/satori/go.uuid"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"go.uber.org/zap"
 
 	"github.com/beyondstorage/beyond-ftp/client"
@@ -23,8 +26,11 @@ import (
 var (
 	cfgFileFlag  string
 	cfgDebugFlag bool
+	hostFlag     string
+	portFlag     int
 
-	clientCount int32
+	// cfgFlags are the flags of the command line, nil if the server is not started by the command.
+	cfgFlags *pflag.FlagSet
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -38,7 +44,8 @@ var rootCmd = &cobra.Command{
 		if cfgDebugFlag {
 			pprof.StartPP()
 		}
-		c, err := config.LoadConfigFromFilepath(cfgFileFlag)
+		cfgFlags = cmd.Flags()
+		c, err := loadConfig()
 		if err != nil {
 			return err
 		}
@@ -50,6 +57,7 @@ var rootCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
+		logWarnings(c)
 		StartServer(s)
 		return zap.L().Sync()
 	},
@@ -65,10 +73,41 @@ func Execute() {
 
 func init() {
 	rootCmd.PersistentFlags().BoolVarP(&cfgDebugFlag, "debug", "d", false, "Enter debug mode")
-	rootCmd.PersistentFlags().StringVarP(&cfgFileFlag, "config", "c", "./config/config.example.toml", "Specify config file")
+	rootCmd.PersistentFlags().StringVarP(&cfgFileFlag, "config", "c", "", "Specify config file")
+	rootCmd.Flags().StringVar(&hostFlag, "host", "127.0.0.1", "Server listen host")
+	rootCmd.Flags().IntVarP(&portFlag, "port", "p", 21, "Server listen port")
+}
+
+// loadConfig loads the config, the flags given take precedence over the
+// config file, which takes precedence over the environment variables.
+func loadConfig() (*config.Config, error) {
+	return config.LoadConfig(cfgFileFlag, os.LookupEnv, func(c *config.Config) {
+		if cfgFlags == nil {
+			return
+		}
+		if cfgFlags.Changed("host") {
+			c.ListenHost = hostFlag
+		}
+		if cfgFlags.Changed("port") {
+			c.ListenPort = portFlag
+		}
+	})
+}
+
+// logWarnings logs the deprecated settings found in the config.
+func logWarnings(c *config.Config) {
+	for _, w := range c.Warnings {
+		zap.L().Warn("Deprecated config", zap.String("path", cfgFileFlag), zap.String("warning", w))
+	}
 }
 
 func StartServer(s server.Server) {
+	Serve(s, client.NewSessions(s.Setting))
+}
+
+// Serve starts the server and serves the clients until it stops, the sessions
+// keep track of the connections.
+func Serve(s server.Server, sessions *client.Sessions) {
 	utils.StartStream(s.Storager())
 	s.Start()
 	go signalHandler(s)
@@ -76,44 +115,100 @@ func StartServer(s server.Server) {
 		connection, addr, err := s.AcceptClient()
 		if err != nil {
 			zap.L().Info("Server stopped", zap.Error(err))
+			shutdown(s, sessions)
 			return
 		}
+		if !s.Setting().IPAllowed(utils.AddrIP(addr)) {
+			go refuseClient(addr, connection, "Connection not allowed from your address")
+			continue
+		}
+		if !sessions.Open(addr) {
+			go refuseClient(addr, connection, "Too many connections")
+			continue
+		}
 
 		id := strings.Replace(uuid.NewV4().String(), "-", "", -1)
-		go serveClient(s, id, addr, connection)
+		go serveClient(s, sessions, id, addr, connection)
 	}
 }
 
-func serveClient(s server.Server, id, addr string, connection utils.Conn) {
+// shutdown waits for the running transfers and the uploads through the
+// streams, until the shutdown timeout of the server.
+func shutdown(s server.Server, sessions *client.Sessions) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(s.Setting().ShutdownTimeout)*time.Second)
+	defer cancel()
+
+	if err := sessions.Shutdown(ctx); err != nil {
+		zap.L().Warn("Sessions closed before their transfers complete", zap.Error(err))
+	}
+	if err := utils.FlushStreams(ctx); err != nil {
+		zap.L().Warn("Uploads not persisted before shutdown", zap.Error(err))
+	}
+}
+
+func serveClient(s server.Server, sessions *client.Sessions, id, addr string, connection utils.Conn) {
+	setting := s.Setting()
 	c := client.NewHandler(
-		id, addr, connection, s.Setting(), s.Storager(), s.PassiveTransferFactory, s.ActiveTransferFactory,
+		id, addr, connection, setting, s.Storager(), s.UserStorager, s.PassiveTransferFactory, s.ActiveTransferFactory,
+		s.TLSConfig(), s.Authenticator(), sessions,
 	)
 
-	count := atomic.AddInt32(&clientCount, 1)
 	zap.L().Info("FTP Client connected",
 		zap.String("id", id),
 		zap.String("remote address", addr),
-		zap.Int32("connection count", count),
+		zap.Int("connection count", sessions.Count()),
 	)
-	c.WriteMessage(client.StatusServiceReady, "Welcome to BeyondFTP Server")
+	c.WriteMessage(client.StatusServiceReady, setting.Banner)
 	c.HandleCommands()
+	// The connection may be left open if the client has not sent QUIT.
+	connection.Close()
 
-	count = atomic.AddInt32(&clientCount, -1)
-	zap.L().Info("FTP Client connected",
+	sessions.Close(addr)
+	zap.L().Info("FTP Client disconnected",
 		zap.String("id", id),
 		zap.String("remote address", addr),
-		zap.Int32("connection count", count),
+		zap.Int("connection count", sessions.Count()),
 	)
 }
 
+// refuseClient replies 421 with the message and closes the connection.
+func refuseClient(addr string, connection utils.Conn, message string) {
+	zap.L().Warn("FTP Client refused", zap.String("remote address", addr), zap.String("reason", message))
+	fmt.Fprintf(connection, "%d %s\r\n", client.StatusServiceNotAvailable, message)
+	connection.Close()
+}
+
+// signalHandler reloads the config on SIGHUP, and stops the server on SIGTERM
+// or SIGINT, the process exits at once on the second one.
 func signalHandler(s server.Server) {
-	ch := make(chan os.Signal, 1)
-	signal.Notify(ch, syscall.SIGTERM)
-	for {
-		switch <-ch {
-		case syscall.SIGTERM:
+	ch := make(chan os.Signal, 2)
+	signal.Notify(ch, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	stopping := false
+	for sig := range ch {
+		switch {
+		case sig == syscall.SIGHUP:
+			reloadConfig(s)
+		case !stopping:
+			zap.L().Info("Shutting down", zap.String("signal", sig.String()))
+			stopping = true
 			s.Stop()
-			return
+		default:
+			zap.L().Warn("Exit without waiting for the sessions", zap.String("signal", sig.String()))
+			os.Exit(1)
 		}
 	}
 }
+
+// reloadConfig reloads the config file, the server keeps its settings if the
+// config is invalid or cannot be reloaded.
+func reloadConfig(s server.Server) {
+	c, err := loadConfig()
+	if err != nil {
+		zap.L().Error("Cannot reload config", zap.String("path", cfgFileFlag), zap.Error(err))
+		return
+	}
+	logWarnings(c)
+	if err := s.Reload(config.GetServerSetting(c)); err != nil {
+		zap.L().Error("Cannot reload config", zap.String("path", cfgFileFlag), zap.Error(err))
+	}
+}
@@ -1,18 +1,214 @@
 package config
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
 	"github.com/BurntSushi/toml"
 )
 
 // A Config stores a configuration of BeyondFTP.
 type Config struct {
-	Service    string            `toml:"service"`
-	ListenHost string            `toml:"host"`
-	ListenPort int               `toml:"port"`
-	PublicHost string            `toml:"public-host"`
-	StartPort  int               `toml:"start-port"`
-	EndPort    int               `toml:"end-port"`
-	Users      map[string]string `toml:"users"`
+	Service    string           `toml:"service"`
+	ListenHost string           `toml:"host"`
+	ListenPort int              `toml:"port"`
+	PublicHost string           `toml:"public-host"`
+	StartPort  int              `toml:"start-port"`
+	EndPort    int              `toml:"end-port"`
+	Banner     string           `toml:"banner"`
+	Users      map[string]*User `toml:"users"`
+	UsersFile  string           `toml:"users-file"`
+	LDAP       *LDAP            `toml:"ldap"`
+	Webhook    *Webhook         `toml:"webhook"`
+	Anonymous  *Anonymous       `toml:"anonymous"`
+
+	LoginProtection *LoginProtection `toml:"login-protection"`
+	AllowNetworks   []string         `toml:"allow-networks"`
+	DenyNetworks    []string         `toml:"deny-networks"`
+
+	MaxConnections        int `toml:"max-connections"`
+	MaxConnectionsPerIP   int `toml:"max-connections-per-ip"`
+	MaxConnectionsPerUser int `toml:"max-connections-per-user"`
+
+	IdleTimeout  int `toml:"idle-timeout"`
+	LoginTimeout int `toml:"login-timeout"`
+	DataTimeout  int `toml:"data-timeout"`
+
+	ShutdownTimeout int `toml:"shutdown-timeout"`
+
+	MaxListDepth   int    `toml:"max-list-depth"`
+	MaxListEntries int    `toml:"max-list-entries"`
+	FileMode       string `toml:"file-mode"`
+	DirMode        string `toml:"dir-mode"`
+
+	MaxAppendRewriteSize int64 `toml:"max-append-rewrite-size"`
+
+	TLSCertFile     string `toml:"tls-cert-file"`
+	TLSKeyFile      string `toml:"tls-key-file"`
+	TLSClientCAFile string `toml:"tls-client-ca-file"`
+	ImplicitTLSPort int    `toml:"implicit-tls-port"`
+	RequireTLS      bool   `toml:"require-tls"`
+	RequireDataTLS  bool   `toml:"require-data-tls"`
+
+	Warnings []string `toml:"-"` // Deprecated settings found by LoadConfig, to be logged
+}
+
+// Values of User.ClientCert.
+const (
+	// ClientCertSufficient means a matching client certificate logs the user in without password.
+	ClientCertSufficient = "sufficient"
+	// ClientCertRequired means both a matching client certificate and the password are required.
+	ClientCertRequired = "required"
+)
+
+// Permissions of the users.
+const (
+	PermissionRead   = "read"   // Download files
+	PermissionWrite  = "write"  // Upload files
+	PermissionDelete = "delete" // Delete files and directories
+	PermissionRename = "rename" // Rename files and directories
+	PermissionMkdir  = "mkdir"  // Create directories
+	PermissionList   = "list"   // List directories and get the status of files
+)
+
+// A User stores the configuration of a FTP user. In the config file, it is
+// either the password of the user or a table.
+type User struct {
+	Password       string   `toml:"password" json:"password"`
+	RequireTLS     *bool    `toml:"require-tls" json:"require-tls"`           // Override the global RequireTLS if set
+	RequireDataTLS *bool    `toml:"require-data-tls" json:"require-data-tls"` // Override the global RequireDataTLS if set
+	ClientCert     string   `toml:"client-cert" json:"client-cert"`           // How the TLS client certificate is used, not used if empty
+	CertNames      []string `toml:"cert-names" json:"cert-names"`             // Certificate subjects or SANs mapped to the user
+	Home           string   `toml:"home" json:"home"`                         // Directory in the storage which becomes the root of the user
+	Service        string   `toml:"service" json:"service"`                   // Connection string of the storage of the user, the global service if empty
+	AllowNetworks  []string `toml:"allow-networks" json:"allow-networks"`     // Networks the user may log in from, any network if empty
+	MaxConnections int      `toml:"max-connections" json:"max-connections"`   // Override the global MaxConnectionsPerUser if not 0, -1 for unlimited
+
+	// Permissions of the user, the user has all the permissions if it is not set.
+	Permissions []string `toml:"permissions" json:"permissions"`
+	// Permissions under the directories of the virtual tree of the user, which
+	// override Permissions. The longest matching directory is used.
+	PathPermissions map[string][]string `toml:"path-permissions" json:"path-permissions"`
+}
+
+// UnmarshalTOML implements toml.Unmarshaler.
+func (u *User) UnmarshalTOML(data interface{}) error {
+	switch v := data.(type) {
+	case string:
+		u.Password = v
+		return nil
+	case map[string]interface{}:
+		// Encode the table back, so that it could be decoded with the field tags.
+		buf := new(bytes.Buffer)
+		if err := toml.NewEncoder(buf).Encode(v); err != nil {
+			return err
+		}
+		type user User
+		_, err := toml.Decode(buf.String(), (*user)(u))
+		return err
+	default:
+		return fmt.Errorf("invalid user %v: expect a password or a table", data)
+	}
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (u *User) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &u.Password); err == nil {
+		return nil
+	}
+	type user User
+	if err := json.Unmarshal(data, (*user)(u)); err != nil {
+		return fmt.Errorf("invalid user %s: expect a password or an object", data)
+	}
+	return nil
+}
+
+// LoginProtection stores the protection against brute-force attacks on the logins.
+type LoginProtection struct {
+	MaxAttempts   int `toml:"max-attempts"`   // Failed logins on a control connection before it is closed, -1 for unlimited
+	MaxFailures   int `toml:"max-failures"`   // Failed logins from an IP or for a user before they are banned, -1 to disable the bans
+	FailureWindow int `toml:"failure-window"` // Seconds without failure after which the failures are forgotten
+	BanDuration   int `toml:"ban-duration"`   // Seconds of the bans
+	Delay         int `toml:"delay"`          // Milliseconds added to the reply of a failed login per failure, -1 to disable
+	MaxDelay      int `toml:"max-delay"`      // Maximum milliseconds of the delay
+}
+
+// Names of the anonymous users, as described in RFC 1635.
+var AnonymousUsers = []string{"anonymous", "ftp"}
+
+// Anonymous stores the policy of the anonymous users, who log in with any
+// password, usually their email address.
+type Anonymous struct {
+	Enabled  bool   `toml:"enabled"`  // Allow the anonymous users to log in
+	Home     string `toml:"home"`     // Directory in the storage which becomes the root of the anonymous users
+	Writable bool   `toml:"writable"` // Allow the anonymous users to modify the storage, they are read-only if false
+	// Directory in the root of the anonymous users where they can upload
+	// files, but not list or download them. Disabled if empty.
+	Incoming string `toml:"incoming"`
+}
+
+// User returns the configuration of the anonymous users.
+func (a *Anonymous) User() *User {
+	u := &User{Home: a.Home}
+	if !a.Writable {
+		u.Permissions = []string{PermissionRead, PermissionList}
+	}
+	if a.Incoming != "" {
+		u.PathPermissions = map[string][]string{
+			path.Clean("/" + a.Incoming): {PermissionWrite},
+		}
+	}
+	return u
+}
+
+// LDAP stores the configuration of the LDAP authentication.
+//
+// The user is bound with the DN built from BindDN if it is set. Otherwise the
+// user is searched under BaseDN with UserFilter, using SearchBindDN to bind
+// for the search, and then bound with the DN of the found entry.
+type LDAP struct {
+	URL                string `toml:"url"`                  // URL of the LDAP server, such as ldap://localhost:389 or ldaps://localhost:636
+	StartTLS           bool   `toml:"start-tls"`            // Upgrade ldap:// connections with StartTLS
+	InsecureSkipVerify bool   `toml:"insecure-skip-verify"` // Do not verify the certificate of the LDAP server
+	Timeout            int    `toml:"timeout"`              // Timeout of the LDAP requests in seconds
+
+	BindDN             string `toml:"bind-dn"`              // Template of the user DN, %s is replaced with the username
+	SearchBindDN       string `toml:"search-bind-dn"`       // DN used for the searches, bind anonymously if empty
+	SearchBindPassword string `toml:"search-bind-password"` // Password of SearchBindDN
+	BaseDN             string `toml:"base-dn"`              // DN under which the users and groups are searched
+	UserFilter         string `toml:"user-filter"`          // Filter to search the user, %s is replaced with the username
+
+	// Filter to search the groups of the user, %s is replaced with the user DN.
+	// If it is set, only the users in at least one group are allowed to log in.
+	GroupFilter string `toml:"group-filter"`
+	// Groups map the groups to the profiles of their members, the first
+	// group the user belongs to is used.
+	Groups []*LDAPGroup `toml:"groups"`
+}
+
+// LDAPGroup maps a LDAP group to the profile of its members.
+type LDAPGroup struct {
+	DN      string `toml:"dn"`      // DN of the group
+	Profile *User  `toml:"profile"` // Configuration of the members, password is ignored
+}
+
+// Webhook stores the configuration of the HTTP webhook authentication.
+//
+// The login requests are POSTed to URL as JSON, and the JSON response decides
+// whether the user is allowed, and the configuration of the user.
+type Webhook struct {
+	URL          string            `toml:"url"`           // URL of the webhook
+	Headers      map[string]string `toml:"headers"`       // Extra headers of the requests, such as Authorization
+	Timeout      int               `toml:"timeout"`       // Timeout of the requests in seconds
+	CacheTTL     int               `toml:"cache-ttl"`     // Seconds to cache the responses, -1 to disable the cache
+	HashPassword bool              `toml:"hash-password"` // Send the SHA-256 of the password instead of the password
 }
 
 // ServerSettings define all the server settings.
@@ -22,7 +218,53 @@ type ServerSettings struct {
 	ListenPort    int        // Port to listen on
 	PublicHost    string     // Public IP to expose (only an IP address is accepted at this stage)
 	DataPortRange *PortRange // Port Range for data connections. Random one will be used if not specified
-	Users         map[string]string
+	Banner        string     // Message of the greeting of the connections
+	Users         map[string]*User
+	UsersFile     string     // Path of the users file, which is reloaded when it changes
+	LDAP          *LDAP      // Configuration of the LDAP authentication, disabled if nil
+	Webhook       *Webhook   // Configuration of the webhook authentication, disabled if nil
+	Anonymous     *Anonymous // Policy of the anonymous users, disabled if nil
+	TLSCertFile   string     // Path of the certificate used by AUTH TLS, TLS is disabled if empty
+	TLSKeyFile    string     // Path of the private key of the certificate
+	// Path of the CA bundle used to verify TLS client certificates.
+	// Client certificates are not requested if it is empty.
+	TLSClientCAFile string
+	// Port to listen on for implicit FTPS, the TLS handshake happens immediately on connect.
+	// Implicit FTPS is disabled if it is 0.
+	ImplicitTLSPort int
+
+	RequireTLS     bool // Refuse to log in unless the control connection is protected by TLS
+	RequireDataTLS bool // Refuse to open data connections unless they are protected by TLS
+
+	LoginProtection *LoginProtection // Protection against brute-force attacks on the logins, disabled if nil
+	// Networks allowed to connect, in CIDR notation or single IPs. Any network
+	// is allowed if it is empty. They also limit the targets of PORT.
+	AllowNetworks []string
+	// Networks refused to connect, they take precedence over AllowNetworks.
+	DenyNetworks []string
+
+	MaxConnections        int // Maximum concurrent sessions of the server, unlimited if 0
+	MaxConnectionsPerIP   int // Maximum concurrent sessions from an IP, unlimited if 0
+	MaxConnectionsPerUser int // Maximum concurrent sessions of a user, unlimited if 0
+
+	IdleTimeout  int // Seconds without command after which the control connection is closed, disabled if not positive
+	LoginTimeout int // Seconds after the connection to complete the login, disabled if not positive
+	DataTimeout  int // Seconds without progress after which a transfer is aborted, disabled if not positive
+
+	// Seconds to wait for the running transfers when the server shuts down,
+	// the sessions are closed at once if it is not positive.
+	ShutdownTimeout int
+
+	MaxListDepth int // Maximum depth of the subdirectories in the recursive listings, unlimited if not positive
+	// Maximum entries of the listings, which are truncated beyond, unlimited
+	// if not positive. The sorted listings are sorted among the first entries.
+	MaxListEntries int
+	FileMode       os.FileMode // Permission bits of the files in the listings, 0644 if 0
+	DirMode        os.FileMode // Permission bits of the directories in the listings, 0755 if 0
+
+	// Maximum size of the files rewritten to append to them when the storage
+	// can't append, APPE is refused on them if it is not positive.
+	MaxAppendRewriteSize int64
 }
 
 // PortRange is a range of ports.
@@ -34,20 +276,39 @@ type PortRange struct {
 // LoadConfigFromFilepath loads configuration from a specified local path.
 // It returns error if file not found or decode failed.
 func LoadConfigFromFilepath(p string) (*Config, error) {
+	return LoadConfig(p, nil, nil)
+}
+
+// LoadConfig loads the configuration in the order of precedence: the flags,
+// the config file at p, the environment variables and the default values.
+// The environment variables are looked up by lookupEnv, and flags sets the
+// values of the flags given, both of them could be nil.
+func LoadConfig(p string, lookupEnv func(string) (string, bool), flags func(*Config)) (*Config, error) {
 	conf := &Config{}
+	if lookupEnv != nil {
+		if err := loadEnv(conf, lookupEnv); err != nil {
+			return nil, err
+		}
+	}
 	if p != "" {
 		if _, err := toml.DecodeFile(p, conf); err != nil {
 			return nil, err
 		}
 	}
+	if flags != nil {
+		flags(conf)
+	}
 	err := setDefaultValue(conf)
 	return conf, err
 }
 
 // setDefaultValue checks the configuration.
 func setDefaultValue(c *Config) error {
+	if c.Service == "" {
+		c.Service = "memory:///ftp"
+	}
 	if c.ListenHost == "" {
-		c.ListenHost = "0.0.0.0"
+		c.ListenHost = "127.0.0.1"
 	}
 	if c.ListenPort == 0 {
 		// For the default value (0), We take the default port (21).
@@ -65,15 +326,242 @@ func setDefaultValue(c *Config) error {
 	if c.EndPort == 0 {
 		c.EndPort = 65535
 	}
+	if c.Banner == "" {
+		c.Banner = "Welcome to BeyondFTP Server"
+	}
+	// The anonymous user used to be configured in the users table, the entry
+	// still enables the anonymous users if the anonymous section is missing.
+	if u, ok := c.Users["anonymous"]; ok && c.Anonymous == nil {
+		c.Anonymous = &Anonymous{Enabled: true, Home: u.Home}
+		delete(c.Users, "anonymous")
+		c.Warnings = append(c.Warnings, "users: the anonymous user is deprecated, enable the anonymous users in the [anonymous] section")
+	}
+	if err := CheckAnonymousUsers(c.Users, c.Anonymous); err != nil {
+		return fmt.Errorf("users: %w", err)
+	}
 	if c.Users == nil {
-		c.Users = make(map[string]string)
-		c.Users["anonymous"] = ""
+		c.Users = make(map[string]*User)
+	}
+	if c.IdleTimeout == 0 {
+		c.IdleTimeout = 300
+	}
+	if c.LoginTimeout == 0 {
+		c.LoginTimeout = 60
+	}
+	if c.DataTimeout == 0 {
+		c.DataTimeout = 300
+	}
+	if c.ShutdownTimeout == 0 {
+		c.ShutdownTimeout = 30
+	}
+	if c.MaxListDepth == 0 {
+		c.MaxListDepth = 8
+	}
+	if c.FileMode == "" {
+		c.FileMode = "0644"
+	}
+	if _, err := parseMode(c.FileMode); err != nil {
+		return fmt.Errorf("file-mode: %w", err)
+	}
+	if c.DirMode == "" {
+		c.DirMode = "0755"
+	}
+	if _, err := parseMode(c.DirMode); err != nil {
+		return fmt.Errorf("dir-mode: %w", err)
+	}
+	if c.MaxAppendRewriteSize == 0 {
+		c.MaxAppendRewriteSize = 64 << 20
+	}
+	if c.LoginProtection == nil {
+		c.LoginProtection = &LoginProtection{}
+	}
+	setLoginProtectionDefaultValue(c.LoginProtection)
+	if err := checkNetworks(c.AllowNetworks); err != nil {
+		return fmt.Errorf("allow-networks: %w", err)
+	}
+	if err := checkNetworks(c.DenyNetworks); err != nil {
+		return fmt.Errorf("deny-networks: %w", err)
+	}
+	if c.LDAP != nil {
+		if err := checkLDAP(c.LDAP); err != nil {
+			return err
+		}
+	}
+	if c.Webhook != nil {
+		if err := checkWebhook(c.Webhook); err != nil {
+			return err
+		}
+	}
+	return CheckUsers(c.Users)
+}
+
+func setLoginProtectionDefaultValue(l *LoginProtection) {
+	if l.MaxAttempts == 0 {
+		l.MaxAttempts = 3
+	}
+	if l.MaxFailures == 0 {
+		l.MaxFailures = 10
+	}
+	if l.FailureWindow == 0 {
+		l.FailureWindow = 600
+	}
+	if l.BanDuration == 0 {
+		l.BanDuration = 900
+	}
+	if l.Delay == 0 {
+		l.Delay = 500
 	}
+	if l.MaxDelay == 0 {
+		l.MaxDelay = 5000
+	}
+}
+
+// checkLDAP checks the configuration of LDAP and sets the default values.
+func checkLDAP(l *LDAP) error {
+	if l.URL == "" {
+		return errors.New("ldap: url is required")
+	}
+	if l.BindDN == "" && l.BaseDN == "" {
+		return errors.New("ldap: either bind-dn or base-dn is required")
+	}
+	if l.GroupFilter == "" && len(l.Groups) > 0 {
+		return errors.New("ldap: group-filter is required to use groups")
+	}
+	if l.GroupFilter != "" && l.BaseDN == "" {
+		return errors.New("ldap: base-dn is required to use group-filter")
+	}
+	if l.UserFilter == "" {
+		l.UserFilter = "(uid=%s)"
+	}
+	if l.Timeout == 0 {
+		l.Timeout = 10
+	}
+	for _, g := range l.Groups {
+		if g.DN == "" {
+			return errors.New("ldap: dn of group is required")
+		}
+		if g.Profile == nil {
+			g.Profile = &User{}
+		}
+	}
+	return nil
+}
+
+// checkWebhook checks the configuration of the webhook and sets the default values.
+func checkWebhook(w *Webhook) error {
+	if w.URL == "" {
+		return errors.New("webhook: url is required")
+	}
+	if w.Timeout == 0 {
+		w.Timeout = 5
+	}
+	if w.CacheTTL == 0 {
+		w.CacheTTL = 30
+	}
+	return nil
+}
+
+// CheckUsers checks the configuration of the users.
+func CheckUsers(users map[string]*User) error {
+	for name, u := range users {
+		switch u.ClientCert {
+		case "", ClientCertSufficient, ClientCertRequired:
+		default:
+			return fmt.Errorf("user %s: invalid client-cert %q", name, u.ClientCert)
+		}
+		if err := checkPermissions(u.Permissions); err != nil {
+			return fmt.Errorf("user %s: %w", name, err)
+		}
+		if err := checkNetworks(u.AllowNetworks); err != nil {
+			return fmt.Errorf("user %s: allow-networks: %w", name, err)
+		}
+		for dir, permissions := range u.PathPermissions {
+			if !path.IsAbs(dir) {
+				return fmt.Errorf("user %s: path-permissions: %s is not an absolute path", name, dir)
+			}
+			if err := checkPermissions(permissions); err != nil {
+				return fmt.Errorf("user %s: path-permissions %s: %w", name, dir, err)
+			}
+		}
+	}
+	return nil
+}
 
+// CheckAnonymousUsers checks that the users don't take the names of the
+// anonymous users while they are enabled.
+func CheckAnonymousUsers(users map[string]*User, anonymous *Anonymous) error {
+	if anonymous == nil || !anonymous.Enabled {
+		return nil
+	}
+	for _, name := range AnonymousUsers {
+		if _, ok := users[name]; ok {
+			return fmt.Errorf("user %s: the name is taken by the anonymous users, which are enabled", name)
+		}
+	}
 	return nil
 }
 
+func checkPermissions(permissions []string) error {
+	for _, p := range permissions {
+		switch p {
+		case PermissionRead, PermissionWrite, PermissionDelete, PermissionRename, PermissionMkdir, PermissionList:
+		default:
+			return fmt.Errorf("invalid permission %q", p)
+		}
+	}
+	return nil
+}
+
+func checkNetworks(networks []string) error {
+	for _, n := range networks {
+		if parseNetwork(n) == nil {
+			return fmt.Errorf("invalid network %q", n)
+		}
+	}
+	return nil
+}
+
+// parseMode parses the octal permission bits of a mode, such as "0644".
+func parseMode(mode string) (os.FileMode, error) {
+	m, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || os.FileMode(m)&^os.ModePerm != 0 {
+		return 0, fmt.Errorf("invalid mode %q", mode)
+	}
+	return os.FileMode(m), nil
+}
+
+// parseNetwork parses a network in CIDR notation or a single IP, it returns nil if it is invalid.
+func parseNetwork(n string) *net.IPNet {
+	if _, network, err := net.ParseCIDR(n); err == nil {
+		return network
+	}
+	ip := net.ParseIP(n)
+	if ip == nil {
+		return nil
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return &net.IPNet{IP: v4, Mask: net.CIDRMask(32, 32)}
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
+
+// inNetworks reports whether the IP is in one of the networks, the invalid networks are ignored.
+func inNetworks(networks []string, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range networks {
+		if network := parseNetwork(n); network != nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
 func GetServerSetting(c *Config) *ServerSettings {
+	// The modes are checked by setDefaultValue.
+	fileMode, _ := parseMode(c.FileMode)
+	dirMode, _ := parseMode(c.DirMode)
 	return &ServerSettings{
 		Service:    c.Service,
 		ListenHost: c.ListenHost,
@@ -83,6 +571,104 @@ func GetServerSetting(c *Config) *ServerSettings {
 			Start: c.StartPort,
 			End:   c.EndPort,
 		},
-		Users: c.Users,
+		Banner:    c.Banner,
+		Users:     c.Users,
+		UsersFile: c.UsersFile,
+		LDAP:      c.LDAP,
+		Webhook:   c.Webhook,
+		Anonymous: c.Anonymous,
+
+		LoginProtection: c.LoginProtection,
+		AllowNetworks:   c.AllowNetworks,
+		DenyNetworks:    c.DenyNetworks,
+
+		MaxConnections:        c.MaxConnections,
+		MaxConnectionsPerIP:   c.MaxConnectionsPerIP,
+		MaxConnectionsPerUser: c.MaxConnectionsPerUser,
+
+		IdleTimeout:  c.IdleTimeout,
+		LoginTimeout: c.LoginTimeout,
+		DataTimeout:  c.DataTimeout,
+
+		ShutdownTimeout: c.ShutdownTimeout,
+
+		MaxListDepth:   c.MaxListDepth,
+		MaxListEntries: c.MaxListEntries,
+		FileMode:       fileMode,
+		DirMode:        dirMode,
+
+		MaxAppendRewriteSize: c.MaxAppendRewriteSize,
+
+		TLSCertFile: c.TLSCertFile,
+		TLSKeyFile:  c.TLSKeyFile,
+
+		TLSClientCAFile: c.TLSClientCAFile,
+
+		ImplicitTLSPort: c.ImplicitTLSPort,
+		RequireTLS:      c.RequireTLS,
+		RequireDataTLS:  c.RequireDataTLS,
+	}
+}
+
+// TLSRequired reports whether the user must log in over a protected control
+// connection, u is nil if the configuration of the user is unknown.
+func (s *ServerSettings) TLSRequired(u *User) bool {
+	if u != nil && u.RequireTLS != nil {
+		return *u.RequireTLS
+	}
+	return s.RequireTLS
+}
+
+// DataTLSRequired reports whether the data connections of the user must be
+// protected, u is nil if the configuration of the user is unknown.
+func (s *ServerSettings) DataTLSRequired(u *User) bool {
+	if u != nil && u.RequireDataTLS != nil {
+		return *u.RequireDataTLS
+	}
+	return s.RequireDataTLS
+}
+
+// IPAllowed reports whether the global lists allow the IP, ip is nil if the
+// address is unknown.
+func (s *ServerSettings) IPAllowed(ip net.IP) bool {
+	if inNetworks(s.DenyNetworks, ip) {
+		return false
+	}
+	return len(s.AllowNetworks) == 0 || inNetworks(s.AllowNetworks, ip)
+}
+
+// IPAllowed reports whether the user may log in from the IP, u is nil if the
+// configuration of the user is unknown.
+func (u *User) IPAllowed(ip net.IP) bool {
+	if u == nil || len(u.AllowNetworks) == 0 {
+		return true
+	}
+	return inNetworks(u.AllowNetworks, ip)
+}
+
+// Permitted reports whether the user has the permission on the path of its
+// virtual tree, u is nil if the configuration of the user is unknown.
+func (u *User) Permitted(permission, p string) bool {
+	if u == nil {
+		return true
+	}
+
+	permissions, all := u.Permissions, u.Permissions == nil
+	longest := -1
+	for dir, dirPermissions := range u.PathPermissions {
+		dir = path.Clean(dir)
+		if len(dir) > longest && (dir == "/" || p == dir || strings.HasPrefix(p, dir+"/")) {
+			longest = len(dir)
+			permissions, all = dirPermissions, false
+		}
+	}
+	if all {
+		return true
+	}
+	for _, v := range permissions {
+		if v == permission {
+			return true
+		}
 	}
+	return false
 }
@@ -0,0 +1,280 @@
+package config
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadExampleConfig(t *testing.T) {
+	c, err := LoadConfigFromFilepath("config.example.toml")
+	assert.Nil(t, err)
+	assert.Equal(t, "memory:///ftp", c.Service)
+	assert.Empty(t, c.Users)
+	assert.Nil(t, c.Anonymous)
+	assert.Equal(t, &LoginProtection{
+		MaxAttempts: 3, MaxFailures: 10, FailureWindow: 600, BanDuration: 900, Delay: 500, MaxDelay: 5000,
+	}, c.LoginProtection)
+	assert.Equal(t, 300, c.IdleTimeout)
+	assert.Equal(t, 60, c.LoginTimeout)
+	assert.Equal(t, 300, c.DataTimeout)
+	assert.Equal(t, 30, c.ShutdownTimeout)
+	assert.Equal(t, 8, c.MaxListDepth)
+	assert.Equal(t, 0, c.MaxListEntries)
+	assert.Equal(t, os.FileMode(0644), GetServerSetting(c).FileMode)
+	assert.Equal(t, os.FileMode(0755), GetServerSetting(c).DirMode)
+	assert.Equal(t, int64(64<<20), c.MaxAppendRewriteSize)
+}
+
+func TestLoadConfigAnonymous(t *testing.T) {
+	c, err := LoadConfigFromFilepath("")
+	assert.Nil(t, err)
+	assert.Nil(t, c.Anonymous)
+
+	// The anonymous user in the users table, the former way, still enables
+	// the anonymous users with a warning.
+	p := filepath.Join(t.TempDir(), "config.toml")
+	err = ioutil.WriteFile(p, []byte(`
+[users]
+anonymous = ""
+`), 0600)
+	assert.Nil(t, err)
+	c, err = LoadConfigFromFilepath(p)
+	assert.Nil(t, err)
+	assert.Empty(t, c.Users)
+	assert.Equal(t, &Anonymous{Enabled: true}, c.Anonymous)
+	assert.Len(t, c.Warnings, 1)
+
+	// The names of the anonymous users are left to the users while the
+	// anonymous users are disabled.
+	err = ioutil.WriteFile(p, []byte(`
+[users]
+ftp = "secret"
+`), 0600)
+	assert.Nil(t, err)
+	c, err = LoadConfigFromFilepath(p)
+	assert.Nil(t, err)
+	assert.Equal(t, "secret", c.Users["ftp"].Password)
+	assert.Nil(t, c.Anonymous)
+	assert.Empty(t, c.Warnings)
+
+	err = ioutil.WriteFile(p, []byte(`
+[anonymous]
+enabled = true
+
+[users]
+ftp = "secret"
+`), 0600)
+	assert.Nil(t, err)
+	_, err = LoadConfigFromFilepath(p)
+	assert.EqualError(t, err, "users: user ftp: the name is taken by the anonymous users, which are enabled")
+
+	err = ioutil.WriteFile(p, []byte(`
+[anonymous]
+enabled = true
+`), 0600)
+	assert.Nil(t, err)
+	c, err = LoadConfigFromFilepath(p)
+	assert.Nil(t, err)
+	assert.Equal(t, &Anonymous{Enabled: true}, c.Anonymous)
+
+	u := (&Anonymous{Home: "/pub", Incoming: "incoming"}).User()
+	assert.Equal(t, "/pub", u.Home)
+	assert.True(t, u.Permitted(PermissionRead, "/file"))
+	assert.False(t, u.Permitted(PermissionWrite, "/file"))
+	assert.True(t, u.Permitted(PermissionWrite, "/incoming/file"))
+	assert.False(t, u.Permitted(PermissionList, "/incoming"))
+	assert.False(t, u.Permitted(PermissionRead, "/incoming/file"))
+	assert.True(t, (&Anonymous{Writable: true}).User().Permitted(PermissionDelete, "/file"))
+}
+
+func TestLoadConfigUsers(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "config.toml")
+	err := ioutil.WriteFile(p, []byte(`
+require-tls = true
+
+[users]
+script = "plain"
+
+[users.partner]
+password = "secret"
+require-tls = false
+require-data-tls = true
+`), 0600)
+	assert.Nil(t, err)
+
+	c, err := LoadConfigFromFilepath(p)
+	assert.Nil(t, err)
+	assert.Equal(t, "plain", c.Users["script"].Password)
+	assert.Nil(t, c.Users["script"].RequireTLS)
+	assert.Equal(t, "secret", c.Users["partner"].Password)
+
+	s := GetServerSetting(c)
+	assert.True(t, s.TLSRequired(c.Users["script"]))
+	assert.False(t, s.DataTLSRequired(c.Users["script"]))
+	assert.False(t, s.TLSRequired(c.Users["partner"]))
+	assert.True(t, s.DataTLSRequired(c.Users["partner"]))
+	assert.True(t, s.TLSRequired(nil))
+}
+
+func TestLoadConfigLDAP(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "config.toml")
+	err := ioutil.WriteFile(p, []byte(`
+[ldap]
+url = "ldap://localhost"
+base-dn = "dc=example,dc=com"
+group-filter = "(member=%s)"
+
+[[ldap.groups]]
+dn = "cn=partners,dc=example,dc=com"
+profile = { require-tls = true }
+
+[[ldap.groups]]
+dn = "cn=staff,dc=example,dc=com"
+`), 0600)
+	assert.Nil(t, err)
+
+	c, err := LoadConfigFromFilepath(p)
+	assert.Nil(t, err)
+	assert.Empty(t, c.Users)
+	assert.Equal(t, "(uid=%s)", c.LDAP.UserFilter)
+	assert.Len(t, c.LDAP.Groups, 2)
+	assert.True(t, *c.LDAP.Groups[0].Profile.RequireTLS)
+	assert.Equal(t, &User{}, c.LDAP.Groups[1].Profile)
+}
+
+func TestUserPermitted(t *testing.T) {
+	var u *User
+	assert.True(t, u.Permitted(PermissionDelete, "/a"))
+
+	u = &User{
+		Permissions: []string{PermissionRead, PermissionList},
+		PathPermissions: map[string][]string{
+			"/incoming":        {PermissionWrite},
+			"/incoming/public": {PermissionWrite, PermissionRead},
+			"/empty":           {},
+		},
+	}
+	assert.Nil(t, CheckUsers(map[string]*User{"u": u}))
+	assert.True(t, u.Permitted(PermissionRead, "/a"))
+	assert.False(t, u.Permitted(PermissionWrite, "/a"))
+	assert.True(t, u.Permitted(PermissionWrite, "/incoming"))
+	assert.True(t, u.Permitted(PermissionWrite, "/incoming/a"))
+	assert.False(t, u.Permitted(PermissionRead, "/incoming/a"))
+	assert.True(t, u.Permitted(PermissionRead, "/incoming/public/a"))
+	assert.True(t, u.Permitted(PermissionRead, "/incomings"))
+	assert.False(t, u.Permitted(PermissionList, "/empty/a"))
+
+	u = &User{PathPermissions: map[string][]string{"/readonly": {PermissionRead}}}
+	assert.True(t, u.Permitted(PermissionDelete, "/a"))
+	assert.False(t, u.Permitted(PermissionDelete, "/readonly/a"))
+
+	assert.NotNil(t, CheckUsers(map[string]*User{"u": {Permissions: []string{"execute"}}}))
+	assert.NotNil(t, CheckUsers(map[string]*User{"u": {PathPermissions: map[string][]string{"relative": {}}}}))
+}
+
+func TestIPAllowed(t *testing.T) {
+	s := &ServerSettings{
+		AllowNetworks: []string{"192.0.2.0/24", "2001:db8::/32", "198.51.100.1"},
+		DenyNetworks:  []string{"192.0.2.128/25"},
+	}
+	assert.True(t, s.IPAllowed(net.ParseIP("192.0.2.1")))
+	assert.True(t, s.IPAllowed(net.ParseIP("2001:db8::1")))
+	assert.True(t, s.IPAllowed(net.ParseIP("198.51.100.1")))
+	assert.False(t, s.IPAllowed(net.ParseIP("198.51.100.2")))
+	assert.False(t, s.IPAllowed(net.ParseIP("192.0.2.129")))
+	assert.False(t, s.IPAllowed(nil))
+	assert.True(t, (&ServerSettings{}).IPAllowed(nil))
+
+	var u *User
+	assert.True(t, u.IPAllowed(net.ParseIP("192.0.2.1")))
+	u = &User{AllowNetworks: []string{"192.0.2.0/24"}}
+	assert.True(t, u.IPAllowed(net.ParseIP("192.0.2.1")))
+	assert.False(t, u.IPAllowed(net.ParseIP("198.51.100.1")))
+
+	p := filepath.Join(t.TempDir(), "config.toml")
+	err := ioutil.WriteFile(p, []byte(`deny-networks = ["192.0.2.0/33"]`), 0600)
+	assert.Nil(t, err)
+	_, err = LoadConfigFromFilepath(p)
+	assert.Error(t, err)
+}
+
+func TestLoadConfigModes(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "config.toml")
+	err := ioutil.WriteFile(p, []byte(`file-mode = "600"`), 0600)
+	assert.Nil(t, err)
+	c, err := LoadConfigFromFilepath(p)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), GetServerSetting(c).FileMode)
+
+	err = ioutil.WriteFile(p, []byte(`dir-mode = "0800"`), 0600)
+	assert.Nil(t, err)
+	_, err = LoadConfigFromFilepath(p)
+	assert.EqualError(t, err, "dir-mode: invalid mode \"0800\"")
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	env := map[string]string{
+		EnvStorager:   "memory:///env",
+		EnvHost:       "192.0.2.1",
+		EnvPort:       "2121",
+		EnvPublicHost: "192.0.2.2",
+		EnvUsers:      `{"alice": "env", "bob": {"password": "env", "home": "/bob"}}`,
+	}
+	lookupEnv := func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+
+	// The environment variables override the default values.
+	c, err := LoadConfig("", lookupEnv, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "memory:///env", c.Service)
+	assert.Equal(t, "192.0.2.1", c.ListenHost)
+	assert.Equal(t, 2121, c.ListenPort)
+	assert.Equal(t, "192.0.2.2", c.PublicHost)
+	assert.Equal(t, &User{Password: "env"}, c.Users["alice"])
+	assert.Equal(t, &User{Password: "env", Home: "/bob"}, c.Users["bob"])
+	assert.Equal(t, 1024, c.StartPort)
+
+	// The config file overrides the environment variables.
+	p := filepath.Join(t.TempDir(), "config.toml")
+	err = ioutil.WriteFile(p, []byte(`
+host = "198.51.100.1"
+port = 2222
+
+[users]
+alice = "file"
+`), 0600)
+	assert.Nil(t, err)
+	c, err = LoadConfig(p, lookupEnv, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "memory:///env", c.Service)
+	assert.Equal(t, "198.51.100.1", c.ListenHost)
+	assert.Equal(t, 2222, c.ListenPort)
+	assert.Equal(t, &User{Password: "file"}, c.Users["alice"])
+	assert.Equal(t, &User{Password: "env", Home: "/bob"}, c.Users["bob"])
+
+	// The flags override the config file.
+	c, err = LoadConfig(p, lookupEnv, func(c *Config) {
+		c.ListenPort = 21
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "198.51.100.1", c.ListenHost)
+	assert.Equal(t, 21, c.ListenPort)
+
+	// Nothing is set.
+	c, err = LoadConfig("", nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "memory:///ftp", c.Service)
+	assert.Equal(t, "127.0.0.1", c.ListenHost)
+	assert.Equal(t, 21, c.ListenPort)
+
+	env[EnvPort] = "ftp"
+	_, err = LoadConfig("", lookupEnv, nil)
+	assert.EqualError(t, err, "BEYOND_FTP_PORT: invalid port \"ftp\"")
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// Environment variables of the configuration, they are overridden by the
+// config file.
+const (
+	EnvStorager   = "BEYOND_FTP_STORAGER" // service
+	EnvHost       = "BEYOND_FTP_HOST"     // host
+	EnvPort       = "BEYOND_FTP_PORT"     // port
+	EnvPublicHost = "BEYOND_FTP_PUB_HOST" // public-host
+	EnvUsers      = "BEYOND_FTP_USERS"    // users, in the format of the JSON users file
+)
+
+// loadEnv sets the configuration from the environment variables looked up by lookupEnv.
+func loadEnv(c *Config, lookupEnv func(string) (string, bool)) error {
+	if v, ok := lookupEnv(EnvStorager); ok {
+		c.Service = v
+	}
+	if v, ok := lookupEnv(EnvHost); ok {
+		c.ListenHost = v
+	}
+	if v, ok := lookupEnv(EnvPort); ok {
+		port, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("%s: invalid port %q", EnvPort, v)
+		}
+		c.ListenPort = port
+	}
+	if v, ok := lookupEnv(EnvPublicHost); ok {
+		c.PublicHost = v
+	}
+	if v, ok := lookupEnv(EnvUsers); ok {
+		if err := json.Unmarshal([]byte(v), &c.Users); err != nil {
+			return fmt.Errorf("%s: %w", EnvUsers, err)
+		}
+	}
+	return nil
+}
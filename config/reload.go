@@ -0,0 +1,64 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// nonReloadable are the fields of ServerSettings which cannot be changed
+// without restarting the server, since they are used to start it.
+var nonReloadable = map[string]bool{
+	"Service":         true,
+	"ListenHost":      true,
+	"ListenPort":      true,
+	"ImplicitTLSPort": true,
+	"TLSCertFile":     true,
+	"TLSKeyFile":      true,
+	"TLSClientCAFile": true,
+}
+
+// Diff returns the names of the fields changed in the other settings. The
+// changed users are listed as "Users.<name>".
+func (s *ServerSettings) Diff(other *ServerSettings) []string {
+	var changed []string
+	a, b := reflect.ValueOf(s).Elem(), reflect.ValueOf(other).Elem()
+	for i := 0; i < a.NumField(); i++ {
+		name := a.Type().Field(i).Name
+		if name == "Users" {
+			changed = append(changed, diffUsers(s.Users, other.Users)...)
+			continue
+		}
+		if !reflect.DeepEqual(a.Field(i).Interface(), b.Field(i).Interface()) {
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
+
+func diffUsers(a, b map[string]*User) []string {
+	var changed []string
+	for name, u := range a {
+		if v, ok := b[name]; !ok || !reflect.DeepEqual(u, v) {
+			changed = append(changed, "Users."+name)
+		}
+	}
+	for name := range b {
+		if _, ok := a[name]; !ok {
+			changed = append(changed, "Users."+name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// CheckReload checks whether the settings could be replaced by the other
+// settings without restarting the server.
+func (s *ServerSettings) CheckReload(other *ServerSettings) error {
+	for _, name := range s.Diff(other) {
+		if nonReloadable[name] {
+			return fmt.Errorf("%s cannot be changed without restart", name)
+		}
+	}
+	return nil
+}
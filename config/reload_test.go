@@ -0,0 +1,27 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServerSettingsDiff(t *testing.T) {
+	c, err := LoadConfigFromFilepath("config.example.toml")
+	assert.Nil(t, err)
+	old := GetServerSetting(c)
+
+	c, err = LoadConfigFromFilepath("config.example.toml")
+	assert.Nil(t, err)
+	c.Users["alice"] = &User{Password: "alice"}
+	c.EndPort = 4096
+	c.MaxConnections = 10
+	s := GetServerSetting(c)
+	assert.Equal(t, []string{"DataPortRange", "Users.alice", "MaxConnections"}, old.Diff(s))
+	assert.Nil(t, old.CheckReload(s))
+	assert.Empty(t, old.Diff(old))
+
+	c.ListenPort = 21
+	s = GetServerSetting(c)
+	assert.EqualError(t, old.CheckReload(s), "ListenPort cannot be changed without restart")
+}
@@ -7,10 +7,14 @@ require (
 	github.com/beyondstorage/go-service-memory v0.2.0
 	github.com/beyondstorage/go-storage/v4 v4.5.0
 	github.com/beyondstorage/go-stream v0.1.0
+	github.com/go-asn1-ber/asn1-ber v1.5.1
+	github.com/go-ldap/ldap/v3 v3.4.1
 	github.com/satori/go.uuid v1.2.0
 	github.com/spf13/cobra v1.2.1
+	github.com/spf13/pflag v1.0.5
 	github.com/stretchr/testify v1.7.0
 	go.uber.org/atomic v1.9.0 // indirect
 	go.uber.org/multierr v1.7.0 // indirect
 	go.uber.org/zap v1.19.0
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
 )
@@ -1,10 +1,12 @@
 package server
 
 import (
+	"crypto/tls"
 	"net"
 
 	"github.com/beyondstorage/go-storage/v4/types"
 
+	"github.com/beyondstorage/beyond-ftp/auth"
 	"github.com/beyondstorage/beyond-ftp/config"
 	"github.com/beyondstorage/beyond-ftp/transfer"
 	"github.com/beyondstorage/beyond-ftp/utils"
@@ -17,12 +19,20 @@ type Server interface {
 	Stop()
 	// AcceptClient return the connection and id when new client is arrived.
 	AcceptClient() (utils.Conn, string, error)
-	// PassiveTransferFactory return a passive transfer handler
-	PassiveTransferFactory(listenHost string, portRange *config.PortRange) (transfer.Handler, int, error)
-	// ActiveTransferFactory return a active transfer handler
-	ActiveTransferFactory(addr *net.TCPAddr) transfer.Handler
-	// Setting return the server setting
+	// PassiveTransferFactory return a passive transfer handler, the connection is wrapped in TLS if tlsConfig is not nil
+	PassiveTransferFactory(listenHost string, portRange *config.PortRange, tlsConfig *tls.Config) (transfer.Handler, int, error)
+	// ActiveTransferFactory return a active transfer handler, the connection is wrapped in TLS if tlsConfig is not nil
+	ActiveTransferFactory(addr *net.TCPAddr, tlsConfig *tls.Config) transfer.Handler
+	// Setting return the current server setting
 	Setting() *config.ServerSettings
+	// Reload replaces the server setting for the new sessions, it fails if the setting cannot be reloaded
+	Reload(setting *config.ServerSettings) error
 	// Storager return the root storager of the server
 	Storager() types.Storager
+	// UserStorager return the storager of the connection string configured for a user, the storagers are shared by the sessions
+	UserStorager(service string) (types.Storager, error)
+	// TLSConfig return the TLS config of the server, nil if TLS is not configured
+	TLSConfig() *tls.Config
+	// Authenticator return the authenticator of the login requests
+	Authenticator() auth.Authenticator
 }
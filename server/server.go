@@ -2,44 +2,128 @@
 package server
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"io"
 	"math/rand"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/beyondstorage/go-storage/v4/types"
 	"go.uber.org/zap"
 
+	"github.com/beyondstorage/beyond-ftp/auth"
 	"github.com/beyondstorage/beyond-ftp/config"
 	"github.com/beyondstorage/beyond-ftp/transfer"
 	"github.com/beyondstorage/beyond-ftp/utils"
 )
 
+// usersFileReloadInterval is the interval to check whether the users file changes.
+const usersFileReloadInterval = 5 * time.Second
+
 // FTPServer is where everything is stored.
 // We want to keep it as simple as possible.
 type FTPServer struct {
-	Listener  net.Listener // Listener used to receive files
-	StartTime time.Time    // Time when the s was started
+	Listener         net.Listener // Listener used to receive files
+	ImplicitListener net.Listener // Listener used to receive files over implicit FTPS
+	StartTime        time.Time    // Time when the s was started
+
+	mu            sync.RWMutex // Guards the settings and the authenticator replaced by Reload
+	setting       *config.ServerSettings
+	storager      types.Storager
+	storagers     *utils.StoragerPool // Storagers of the users having their own service
+	tlsConfig     *tls.Config
+	authenticator auth.Authenticator
+	custom        bool // The authenticator was set by SetAuthenticator, Reload keeps it
+
+	accepted chan acceptedConn // connections accepted by all listeners
+	done     chan struct{}     // closed when the server stops
+}
 
-	setting  *config.ServerSettings
-	storager types.Storager
+type acceptedConn struct {
+	conn net.Conn
+	err  error
 }
 
 func (s *FTPServer) Storager() types.Storager {
 	return s.storager
 }
 
+func (s *FTPServer) UserStorager(service string) (types.Storager, error) {
+	// The service cannot be reloaded.
+	if service == s.Setting().Service {
+		return s.storager, nil
+	}
+	return s.storagers.Get(service)
+}
+
 func (s *FTPServer) Setting() *config.ServerSettings {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return s.setting
 }
 
-func (s *FTPServer) AcceptClient() (utils.Conn, string, error) {
-	conn, err := s.Listener.Accept()
+func (s *FTPServer) TLSConfig() *tls.Config {
+	return s.tlsConfig
+}
+
+func (s *FTPServer) Authenticator() auth.Authenticator {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.authenticator
+}
+
+// SetAuthenticator replaces the default authenticator, which checks the users in the config.
+// The authenticator is protected against brute-force attacks like the default one.
+func (s *FTPServer) SetAuthenticator(a auth.Authenticator) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.authenticator = auth.NewGuard(a, s.setting.LoginProtection)
+	s.custom = true
+}
+
+// Reload replaces the settings of the server, and rebuilds the backends of the
+// authenticator unless it was set by SetAuthenticator, the failed logins are
+// kept. The settings are used by the
+// sessions started afterwards. The reload is refused if it changes the settings used to start
+// the server.
+func (s *FTPServer) Reload(setting *config.ServerSettings) error {
+	old := s.Setting()
+	if err := old.CheckReload(setting); err != nil {
+		return err
+	}
+	if err := checkSetting(setting, s.tlsConfig); err != nil {
+		return err
+	}
+
+	s.mu.RLock()
+	current, custom := s.authenticator, s.custom
+	s.mu.RUnlock()
+	authenticator, err := ReloadAuthenticator(current, custom, setting)
 	if err != nil {
-		return nil, "", err
+		return err
+	}
+
+	s.mu.Lock()
+	s.setting = setting
+	s.authenticator = authenticator
+	s.mu.Unlock()
+	zap.L().Info("Config reloaded", zap.Strings("changed", old.Diff(setting)))
+	return nil
+}
+
+func (s *FTPServer) AcceptClient() (utils.Conn, string, error) {
+	select {
+	case a := <-s.accepted:
+		if a.err != nil {
+			return nil, "", a.err
+		}
+		return a.conn, a.conn.RemoteAddr().String(), nil
+	case <-s.done:
+		return nil, "", errors.New("server stopped")
 	}
-	return conn, conn.RemoteAddr().String(), nil
 }
 
 func (s *FTPServer) Start() {
@@ -50,11 +134,45 @@ func (s *FTPServer) Start() {
 	if err != nil {
 		zap.L().Fatal("Cannot listen: ", zap.Error(err))
 	}
+	go s.serve(s.Listener)
 
 	zap.L().Info("Listening...", zap.String("address", s.Listener.Addr().String()))
+
+	if s.setting.ImplicitTLSPort == 0 {
+		return
+	}
+
+	l, err := net.Listen("tcp", fmt.Sprintf(
+		"%s:%d", s.setting.ListenHost, s.setting.ImplicitTLSPort,
+	))
+	if err != nil {
+		zap.L().Fatal("Cannot listen: ", zap.Error(err))
+	}
+	s.ImplicitListener = tls.NewListener(l, s.tlsConfig)
+	go s.serve(s.ImplicitListener)
+
+	zap.L().Info("Listening implicit FTPS...", zap.String("address", l.Addr().String()))
 }
 
-func (s *FTPServer) PassiveTransferFactory(listenHost string, portRange *config.PortRange) (transfer.Handler, int, error) {
+// serve accepts connections from the listener until it is closed.
+func (s *FTPServer) serve(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		select {
+		case s.accepted <- acceptedConn{conn: conn, err: err}:
+		case <-s.done:
+			if conn != nil {
+				conn.Close()
+			}
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (s *FTPServer) PassiveTransferFactory(listenHost string, portRange *config.PortRange, tlsConfig *tls.Config) (transfer.Handler, int, error) {
 	var tcpListener *net.TCPListener
 	var err error
 	var localAddr *net.TCPAddr
@@ -86,26 +204,45 @@ func (s *FTPServer) PassiveTransferFactory(listenHost string, portRange *config.
 		return nil, 0, errors.New("cannot listen")
 	}
 
+	var listener net.Listener = tcpListener
+	if tlsConfig != nil {
+		listener = tls.NewListener(tcpListener, tlsConfig)
+	}
+
 	p := &transfer.PassiveHandler{
 		TCPListener: tcpListener,
-		Listener:    tcpListener,
+		Listener:    listener,
 	}
 
 	return p, tcpListener.Addr().(*net.TCPAddr).Port, nil
 }
 
-func (s *FTPServer) ActiveTransferFactory(addr *net.TCPAddr) transfer.Handler {
+func (s *FTPServer) ActiveTransferFactory(addr *net.TCPAddr, tlsConfig *tls.Config) transfer.Handler {
 	return &transfer.ActiveHandler{
 		RemoteAddr: addr,
+		TLSConfig:  tlsConfig,
 	}
 }
 
-// Stop closes the listener.
+// Stop closes the listeners.
 func (s *FTPServer) Stop() {
-	if s.Listener != nil {
-		l := s.Listener
-		s.Listener = nil
-		l.Close()
+	select {
+	case <-s.done:
+		return
+	default:
+		close(s.done)
+	}
+
+	for _, l := range []net.Listener{s.Listener, s.ImplicitListener} {
+		if l != nil {
+			l.Close()
+		}
+	}
+	s.Listener = nil
+	s.ImplicitListener = nil
+
+	if c, ok := s.Authenticator().(io.Closer); ok {
+		c.Close()
 	}
 }
 
@@ -116,9 +253,107 @@ func NewFTPServer(c *config.Config) (*FTPServer, error) {
 	if err != nil {
 		return nil, err
 	}
+	tlsConfig, err := newTLSConfig(setting)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSetting(setting, tlsConfig); err != nil {
+		return nil, err
+	}
+	authenticator, err := NewAuthenticator(setting)
+	if err != nil {
+		return nil, err
+	}
 	return &FTPServer{
-		StartTime: time.Now().UTC(),
-		setting:   setting,
-		storager:  storager,
+		StartTime:     time.Now().UTC(),
+		setting:       setting,
+		storager:      storager,
+		storagers:     utils.NewStoragerPool(),
+		tlsConfig:     tlsConfig,
+		authenticator: authenticator,
+		accepted:      make(chan acceptedConn),
+		done:          make(chan struct{}),
 	}, nil
 }
+
+// checkSetting checks whether the settings could be served with the TLS config.
+func checkSetting(setting *config.ServerSettings, tlsConfig *tls.Config) error {
+	if setting.ImplicitTLSPort != 0 && tlsConfig == nil {
+		return errors.New("implicit FTPS requires a TLS certificate")
+	}
+	if (setting.RequireTLS || setting.RequireDataTLS) && tlsConfig == nil {
+		return errors.New("requiring TLS needs a TLS certificate")
+	}
+	for name, u := range setting.Users {
+		if u.ClientCert != "" && (tlsConfig == nil || tlsConfig.ClientCAs == nil) {
+			return fmt.Errorf("user %s uses client certificate, but no TLS client CA is configured", name)
+		}
+	}
+	return auth.CheckPasswords(setting.Users)
+}
+
+// NewAuthenticator creates the authenticator of the settings, protected by a
+// guard against brute-force attacks.
+func NewAuthenticator(setting *config.ServerSettings) (auth.Authenticator, error) {
+	a, err := newBackends(setting)
+	if err != nil {
+		return nil, err
+	}
+	return auth.NewGuard(a, setting.LoginProtection), nil
+}
+
+// ReloadAuthenticator returns the authenticator of the reloaded settings. The
+// guard of the current authenticator is kept with the failed logins, only the
+// backends it protects are replaced. The backends of a custom authenticator,
+// set by SetAuthenticator, are kept and only the protection is reloaded.
+func ReloadAuthenticator(current auth.Authenticator, custom bool, setting *config.ServerSettings) (auth.Authenticator, error) {
+	g, ok := current.(*auth.Guard)
+	if ok && custom {
+		g.SetConfig(setting.LoginProtection)
+		return g, nil
+	}
+
+	a, err := newBackends(setting)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		g.Reload(a, setting.LoginProtection)
+		return g, nil
+	}
+	return auth.NewGuard(a, setting.LoginProtection), nil
+}
+
+// newBackends creates the authenticator of the backends of the settings. The
+// anonymous users are checked first, then the users table or the users file,
+// and then the other backends in order.
+func newBackends(setting *config.ServerSettings) (auth.Authenticator, error) {
+	var authenticators []auth.Authenticator
+	if setting.Anonymous != nil && setting.Anonymous.Enabled {
+		authenticators = append(authenticators, auth.NewAnonymousAuthenticator(setting.Anonymous))
+	}
+	if setting.UsersFile != "" {
+		a, err := auth.NewFileAuthenticator(setting.UsersFile, setting.Users, setting.Anonymous, usersFileReloadInterval)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, a)
+	} else {
+		authenticators = append(authenticators, auth.NewUsersAuthenticator(setting.Users))
+	}
+	if setting.LDAP != nil {
+		a, err := auth.NewLDAPAuthenticator(setting.LDAP)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, a)
+	}
+	if setting.Webhook != nil {
+		authenticators = append(authenticators, auth.NewWebhookAuthenticator(setting.Webhook))
+	}
+
+	if len(authenticators) == 1 {
+		return authenticators[0], nil
+	}
+	return auth.NewChainAuthenticator(authenticators...), nil
+}
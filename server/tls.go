@@ -0,0 +1,44 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/beyondstorage/beyond-ftp/config"
+)
+
+// newTLSConfig loads the certificate from the server setting. It returns nil
+// if no certificate is configured, which means TLS is disabled.
+func newTLSConfig(setting *config.ServerSettings) (*tls.Config, error) {
+	if setting.TLSCertFile == "" && setting.TLSKeyFile == "" {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(setting.TLSCertFile, setting.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load tls certificate: %w", err)
+	}
+
+	c := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if setting.TLSClientCAFile != "" {
+		bundle, err := ioutil.ReadFile(setting.TLSClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("load tls client ca: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("load tls client ca: no certificate found in %s", setting.TLSClientCAFile)
+		}
+		// Clients without certificate are still allowed to log in with password.
+		c.ClientCAs = pool
+		c.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return c, nil
+}
@@ -1,7 +1,10 @@
 package tests
 
 import (
+	"fmt"
+	"io"
 	"math/rand"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -10,6 +13,9 @@ import (
 	"github.com/stretchr/testify/suite"
 	"go.uber.org/zap"
 
+	"github.com/beyondstorage/beyond-ftp/auth"
+	"github.com/beyondstorage/beyond-ftp/client"
+	"github.com/beyondstorage/beyond-ftp/config"
 	"github.com/beyondstorage/beyond-ftp/pprof"
 	"github.com/beyondstorage/beyond-ftp/tests/kit"
 )
@@ -109,9 +115,10 @@ func (t *ftpServerBaseCommandTest) TestCommandExecution() {
 
 func (t *ftpServerBaseCommandTest) TestUserCommand() {
 	myConfig := *kit.DefaultServerSetting
-	myConfig.Users = map[string]string{
-		"test1": "test1",
+	myConfig.Users = map[string]*config.User{
+		"test1": {Password: "test1"},
 	}
+	myConfig.Anonymous = nil
 	tk := kit.NewTestKitWithConfig(t.T(), &myConfig)
 	defer tk.Stop()
 
@@ -126,6 +133,570 @@ func (t *ftpServerBaseCommandTest) TestUserCommand() {
 	tk.MustFailure(conn, "pass test1")
 }
 
+func (t *ftpServerBaseCommandTest) TestCustomAuthenticator() {
+	var received *auth.Request
+	a := auth.AuthenticatorFunc(func(req *auth.Request) (*auth.Identity, error) {
+		if req.WithoutPassword {
+			return nil, auth.ErrPasswordRequired
+		}
+		received = req
+		if req.Password != "from-database" {
+			return nil, auth.ErrInvalidCredentials
+		}
+		return &auth.Identity{User: "db-" + req.User}, nil
+	})
+	myConfig := *kit.DefaultServerSetting
+	myConfig.LoginProtection = &config.LoginProtection{MaxAttempts: -1, MaxFailures: 2, FailureWindow: 60, BanDuration: 60, Delay: -1}
+	tk := kit.NewTestKitWithAuthenticator(t.T(), &myConfig, a)
+	defer tk.Stop()
+
+	conn := tk.Dail()
+	tk.MustSuccess(conn, "user anonymous")
+	tk.MustFailure(conn, "pass")
+	tk.MustSuccess(conn, "user alice")
+	tk.MustSuccess(conn, "pass from-database")
+	assert.Equal(t.T(), "alice", received.User)
+	assert.Nil(t.T(), received.TLS)
+	tk.Send(conn, "stat").Success()
+
+	// The authenticator is kept across the reloads.
+	reloaded := myConfig
+	assert.Nil(t.T(), tk.Reload(&reloaded))
+	tk.Login("bob", "from-database")
+
+	// The authenticator is protected against brute-force attacks, the IP is
+	// banned after the second failure.
+	conn = tk.Dail()
+	tk.MustSuccess(conn, "user mallory")
+	tk.MustFailure(conn, "pass guess")
+	tk.Send(conn, "user mallory").Auto().Failure("Too many failed login attempts, closing control connection")
+}
+
+func (t *ftpServerBaseCommandTest) TestBackendSettings() {
+	backend := auth.AuthenticatorFunc(func(req *auth.Request) (*auth.Identity, error) {
+		if req.WithoutPassword {
+			return nil, auth.ErrPasswordRequired
+		}
+		if req.Password != "from-backend" {
+			return nil, auth.ErrInvalidCredentials
+		}
+		return &auth.Identity{User: req.User}, nil
+	})
+	local := auth.NewUsersAuthenticator(map[string]*config.User{
+		"alice": {Password: "alice", AllowNetworks: []string{"198.51.100.0/24"}},
+	})
+	tk := kit.NewTestKitWithAuthenticator(t.T(), kit.DefaultServerSetting, auth.NewChainAuthenticator(local, backend))
+	defer tk.Stop()
+
+	// The user of the backend does not get the settings of the local user of the same name.
+	conn := tk.Dail()
+	tk.MustSuccess(conn, "user alice")
+	tk.MustFailure(conn, "pass alice")
+	tk.MustSuccess(conn, "user alice")
+	tk.MustSuccess(conn, "pass from-backend")
+}
+
+func (t *ftpServerBaseCommandTest) TestHomeDirectory() {
+	myConfig := *kit.DefaultServerSetting
+	myConfig.Users = map[string]*config.User{
+		"alice": {Password: "alice", Home: "/alice"},
+	}
+	tk := kit.NewTestKitWithConfig(t.T(), &myConfig)
+	defer tk.Stop()
+
+	root := tk.AnonymousLogin()
+	tk.MustSuccess(root, "mkd alice")
+	tk.Store(root, "secret", []byte("secret"))
+	tk.Store(root, "alice/own", []byte("own"))
+
+	conn := tk.Login("alice", "alice")
+	tk.Send(conn, "pwd").Success(`"/" is the current directory`)
+	assert.Equal(t.T(), []string{
+		"-rw-r--r--    1 alice    alice               3 Jan  1  1970 own",
+	}, tk.List(conn, ""))
+	assert.Equal(t.T(), []string{
+		"-rw-r--r--    1 alice    alice               3 Jan  1  1970 own",
+	}, tk.List(conn, "../.."))
+
+	tk.MustFailure(conn, "cdup")
+	tk.MustSuccess(conn, "cwd ../..")
+	tk.Send(conn, "pwd").Success(`"/" is the current directory`)
+	tk.MustFailure(conn, "size ../secret")
+	tk.MustFailure(conn, "size /../secret")
+	// Deleting a missing object succeeds, the root listing below shows secret is kept.
+	tk.MustSuccess(conn, "dele ../secret")
+	tk.MustFailure(conn, "rnfr /../secret")
+	tk.Send(conn, "size /../own").Success("3")
+	assert.Equal(t.T(), []byte("own"), tk.Retrieve(conn, "../own"))
+
+	tk.Store(conn, "../../uploaded", []byte("uploaded"))
+	tk.MustSuccess(conn, "mkd ../dir")
+	tk.MustSuccess(conn, "rnfr own")
+	tk.MustSuccess(conn, "rnto /../../renamed")
+
+	assert.Equal(t.T(), []string{
+		"-rw-r--r--    1 anonymous anonymous            6 Jan  1  1970 secret",
+		"drwxr-xr-x    2 anonymous anonymous            0 Jan  1  1970 alice",
+	}, tk.List(root, ""))
+	assert.Equal(t.T(), []string{
+		"-rw-r--r--    1 anonymous anonymous            3 Jan  1  1970 renamed",
+		"-rw-r--r--    1 anonymous anonymous            8 Jan  1  1970 uploaded",
+		"drwxr-xr-x    2 anonymous anonymous            0 Jan  1  1970 dir",
+	}, tk.List(root, "alice"))
+}
+
+func (t *ftpServerBaseCommandTest) TestUserStorager() {
+	myConfig := *kit.DefaultServerSetting
+	myConfig.Users = map[string]*config.User{
+		"partner": {Password: "partner", Service: "memory:///partner"},
+		"broken":  {Password: "broken", Service: "unknown:///"},
+	}
+	tk := kit.NewTestKitWithConfig(t.T(), &myConfig)
+	defer tk.Stop()
+
+	root := tk.AnonymousLogin()
+	tk.Store(root, "root", []byte("root"))
+
+	conn := tk.Login("partner", "partner")
+	tk.Store(conn, "partner", []byte("partner"))
+	tk.MustFailure(conn, "size root")
+
+	// The storager is shared by the sessions of the user.
+	another := tk.Login("partner", "partner")
+	assert.Equal(t.T(), []byte("partner"), tk.Retrieve(another, "partner"))
+
+	assert.Equal(t.T(), []string{
+		"-rw-r--r--    1 anonymous anonymous            4 Jan  1  1970 root",
+	}, tk.List(root, ""))
+
+	conn = tk.Dail()
+	tk.MustSuccess(conn, "user broken")
+	tk.MustFailure(conn, "pass broken")
+	tk.MustFailure(conn, "pwd")
+}
+
+func (t *ftpServerBaseCommandTest) TestPermissions() {
+	myConfig := *kit.DefaultServerSetting
+	myConfig.Users = map[string]*config.User{
+		"reader": {Password: "reader", Permissions: []string{config.PermissionRead, config.PermissionList}},
+		"dropbox": {
+			Password:        "dropbox",
+			Permissions:     []string{},
+			PathPermissions: map[string][]string{"/incoming": {config.PermissionWrite}},
+		},
+	}
+	tk := kit.NewTestKitWithConfig(t.T(), &myConfig)
+	defer tk.Stop()
+
+	root := tk.AnonymousLogin()
+	tk.MustSuccess(root, "mkd incoming")
+	tk.Store(root, "file", []byte("file"))
+
+	reader := tk.Login("reader", "reader")
+	assert.Equal(t.T(), []byte("file"), tk.Retrieve(reader, "file"))
+	tk.Send(reader, "size file").Success("4")
+	tk.Send(reader, "dele file").Failure("Permission denied")
+	tk.Send(reader, "rnfr file").Failure("Permission denied")
+	tk.Send(reader, "mkd dir").Failure("Permission denied")
+	tk.Send(reader, "stor new").Failure("Permission denied")
+
+	dropbox := tk.Login("dropbox", "dropbox")
+	tk.Send(dropbox, "list").Failure("Permission denied")
+	tk.Send(dropbox, "retr file").Failure("Permission denied")
+	tk.Send(dropbox, "stor file").Failure("Permission denied")
+	tk.Send(dropbox, "size incoming/uploaded").Failure("Permission denied")
+	tk.Store(dropbox, "incoming/uploaded", []byte("uploaded"))
+	tk.Send(dropbox, "stat").Success()
+
+	tk.Send(root, "size incoming/uploaded").Success("8")
+}
+
+func (t *ftpServerBaseCommandTest) TestAnonymousPolicy() {
+	myConfig := *kit.DefaultServerSetting
+	myConfig.Users = map[string]*config.User{"admin": {Password: "admin"}}
+	myConfig.Anonymous = &config.Anonymous{Enabled: true, Home: "/pub", Incoming: "/incoming"}
+	tk := kit.NewTestKitWithConfig(t.T(), &myConfig)
+	defer tk.Stop()
+
+	admin := tk.Login("admin", "admin")
+	tk.MustSuccess(admin, "mkd pub")
+	tk.MustSuccess(admin, "mkd pub/incoming")
+	tk.Store(admin, "pub/file", []byte("file"))
+
+	conn := tk.Login("ftp", "guest@example.com")
+	assert.Equal(t.T(), []byte("file"), tk.Retrieve(conn, "file"))
+	tk.Send(conn, "stor new").Failure("Permission denied")
+	tk.Send(conn, "dele file").Failure("Permission denied")
+	tk.Send(conn, "mkd dir").Failure("Permission denied")
+	tk.Store(conn, "incoming/upload", []byte("upload"))
+	tk.Send(conn, "list incoming").Failure("Permission denied")
+	tk.Send(conn, "retr incoming/upload").Failure("Permission denied")
+	tk.Send(admin, "size pub/incoming/upload").Success("6")
+
+	myConfig.Anonymous = &config.Anonymous{Enabled: false}
+	disabled := kit.NewTestKitWithConfig(t.T(), &myConfig)
+	defer disabled.Stop()
+	conn = disabled.Dail()
+	disabled.MustSuccess(conn, "user anonymous")
+	disabled.MustFailure(conn, "pass guest@example.com")
+}
+
+func (t *ftpServerBaseCommandTest) TestLoginAttempts() {
+	myConfig := *kit.DefaultServerSetting
+	myConfig.Users = map[string]*config.User{"test": {Password: "test"}}
+	myConfig.LoginProtection = &config.LoginProtection{MaxAttempts: 2, MaxFailures: -1, FailureWindow: 60, Delay: -1}
+	tk := kit.NewTestKitWithConfig(t.T(), &myConfig)
+	defer tk.Stop()
+
+	conn := tk.Dail()
+	tk.Send(conn, "user test").Auto().Another()
+	tk.Send(conn, "pass wrong").Auto().Failure()
+	tk.Send(conn, "user test").Auto().Another()
+	tk.Send(conn, "pass wrong").Auto().Failure("Too many failed login attempts, closing control connection")
+	_, err := conn.Read(make([]byte, 1))
+	assert.Equal(t.T(), io.EOF, err)
+
+	conn = tk.Login("test", "test")
+	tk.MustSuccess(conn, "pwd")
+}
+
+func (t *ftpServerBaseCommandTest) TestNetworks() {
+	myConfig := *kit.DefaultServerSetting
+	myConfig.Users = map[string]*config.User{
+		"test": {Password: "test", AllowNetworks: []string{"192.0.2.0/24"}},
+	}
+	myConfig.AllowNetworks = []string{"192.0.2.0/24", "198.51.100.1"}
+	myConfig.DenyNetworks = []string{"192.0.2.128/25"}
+	tk := kit.NewTestKitWithConfig(t.T(), &myConfig)
+	defer tk.Stop()
+
+	conn, greeting := tk.DailFrom("203.0.113.1:1000")
+	greeting.EqualCode(client.StatusServiceNotAvailable)
+	_, err := conn.Read(make([]byte, 1))
+	assert.Equal(t.T(), io.EOF, err)
+
+	conn, greeting = tk.DailFrom("192.0.2.200:1000")
+	greeting.EqualCode(client.StatusServiceNotAvailable)
+
+	conn, greeting = tk.DailFrom("198.51.100.1:1000")
+	greeting.EqualCode(client.StatusServiceReady)
+	tk.Send(conn, "user test").Auto().Another()
+	tk.Send(conn, "pass test").Auto().Failure("Login not allowed from your address")
+
+	conn, _ = tk.DailFrom("192.0.2.1:1000")
+	tk.Send(conn, "user test").Auto().Another()
+	tk.Send(conn, "pass test").Auto().Success()
+	tk.Send(conn, "port 192,0,2,1,4,0").Success()
+	tk.Send(conn, "port 192,0,2,200,4,0").Failure("PORT target not allowed")
+	tk.Send(conn, "port 10,0,0,1,4,0").Failure("PORT target not allowed")
+}
+
+func (t *ftpServerBaseCommandTest) TestConnectionLimits() {
+	myConfig := *kit.DefaultServerSetting
+	myConfig.Users = map[string]*config.User{
+		"test":   {Password: "test"},
+		"single": {Password: "single", MaxConnections: 1},
+	}
+	myConfig.MaxConnections = 4
+	myConfig.MaxConnectionsPerIP = 2
+	myConfig.MaxConnectionsPerUser = 2
+	tk := kit.NewTestKitWithConfig(t.T(), &myConfig)
+	defer tk.Stop()
+
+	login := func(addr, user string) *kit.Result {
+		conn, greeting := tk.DailFrom(addr)
+		tk.Send(conn, "user "+user).Auto().Another()
+		tk.Send(conn, "pass "+user).Auto().Success()
+		return greeting
+	}
+
+	login("192.0.2.1:1000", "test")
+	login("192.0.2.1:1001", "single")
+	conn, greeting := tk.DailFrom("192.0.2.1:1002")
+	greeting.EqualCode(client.StatusServiceNotAvailable)
+	greeting.EqualMsg("Too many connections")
+	_, err := conn.Read(make([]byte, 1))
+	assert.Equal(t.T(), io.EOF, err)
+
+	conn, _ = tk.DailFrom("192.0.2.2:1000")
+	tk.Send(conn, "user single").Auto().Another()
+	tk.Send(conn, "pass single").Auto().Failure("Too many connections")
+	_, err = conn.Read(make([]byte, 1))
+	assert.Equal(t.T(), io.EOF, err)
+	tk.WaitSessions(2)
+
+	login("192.0.2.2:1001", "test")
+	conn, _ = tk.DailFrom("192.0.2.3:1000")
+	tk.Send(conn, "user test").Auto().Another()
+	tk.Send(conn, "pass test").Auto().Failure("Too many connections")
+	tk.WaitSessions(3)
+
+	conn, _ = tk.DailFrom("192.0.2.3:1001")
+	tk.WaitSessions(4)
+	_, greeting = tk.DailFrom("192.0.2.4:1000")
+	greeting.EqualCode(client.StatusServiceNotAvailable)
+
+	// The sessions are released when the clients quit.
+	tk.MustSuccess(conn, "quit")
+	tk.WaitSessions(3)
+	conn, _ = tk.DailFrom("192.0.2.5:1000")
+	tk.MustSuccess(conn, "quit")
+}
+
+func (t *ftpServerBaseCommandTest) TestTimeouts() {
+	myConfig := *kit.DefaultServerSetting
+	myConfig.IdleTimeout = 1
+	myConfig.LoginTimeout = 2
+	myConfig.DataTimeout = 1
+	tk := kit.NewTestKitWithConfig(t.T(), &myConfig)
+	defer tk.Stop()
+
+	// The login timeout is not extended by the commands.
+	conn := tk.Dail()
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		time.Sleep(500 * time.Millisecond)
+		tk.MustSuccess(conn, "noop")
+	}
+	tk.Receive(conn).EqualMsg("Login timeout, closing control connection")
+	assert.True(t.T(), time.Since(start) < 3*time.Second)
+
+	conn = tk.AnonymousLogin()
+	passive := tk.PassiveConn(conn)
+	tk.Send(conn, "stor file").Wait()
+	tk.Receive(conn).EqualMsg("Data connection stalled; transfer aborted")
+	passive.Close()
+
+	result := tk.Receive(conn)
+	result.EqualCode(client.StatusServiceNotAvailable)
+	result.EqualMsg("Idle timeout, closing control connection")
+	_, err := conn.Read(make([]byte, 1))
+	assert.Equal(t.T(), io.EOF, err)
+}
+
+func (t *ftpServerBaseCommandTest) TestShutdown() {
+	myConfig := *kit.DefaultServerSetting
+	myConfig.ShutdownTimeout = 5
+	tk := kit.NewTestKitWithConfig(t.T(), &myConfig)
+
+	idle := tk.AnonymousLogin()
+	conn := tk.AnonymousLogin()
+	passive := tk.PassiveConn(conn)
+	tk.Send(conn, "stor file").Wait()
+	_, err := passive.Write([]byte("file"))
+	assert.Nil(t.T(), err)
+
+	tk.Stop()
+	tk.Receive(idle).EqualMsg("Server is shutting down, closing control connection")
+
+	// The running transfer completes before its session is closed.
+	passive.Close()
+	tk.Receive(conn).EqualCode(client.StatusClosingDataConn)
+	tk.Receive(conn).EqualMsg("Server is shutting down, closing control connection")
+	_, err = conn.Read(make([]byte, 1))
+	assert.Equal(t.T(), io.EOF, err)
+}
+
+func (t *ftpServerBaseCommandTest) TestReload() {
+	myConfig := *kit.DefaultServerSetting
+	tk := kit.NewTestKitWithConfig(t.T(), &myConfig)
+	defer tk.Stop()
+
+	existing := tk.AnonymousLogin()
+	conn := tk.Dail()
+	tk.Send(conn, "user alice").Auto().Another()
+	tk.Send(conn, "pass alice").Auto().Failure()
+
+	reloaded := myConfig
+	reloaded.Banner = "Hello"
+	reloaded.Users = map[string]*config.User{"alice": {Password: "alice"}}
+	reloaded.Anonymous = nil
+	assert.Nil(t.T(), tk.Reload(&reloaded))
+
+	conn, greeting := tk.DailFrom("")
+	greeting.EqualMsg("Hello")
+	tk.Send(conn, "user alice").Auto().Another()
+	tk.Send(conn, "pass alice").Auto().Success()
+	tk.MustSuccess(existing, "pwd")
+
+	refused := reloaded
+	refused.ListenPort = 2121
+	assert.EqualError(t.T(), tk.Reload(&refused), "ListenPort cannot be changed without restart")
+	_, greeting = tk.DailFrom("")
+	greeting.EqualMsg("Hello")
+}
+
+func (t *ftpServerBaseCommandTest) TestNameList() {
+	tk := kit.NewTestKit(t.T())
+	defer tk.Stop()
+
+	conn := tk.AnonymousLogin()
+	tk.MustSuccess(conn, "mkd dir")
+	tk.Store(conn, "file", []byte("file content"))
+	tk.Store(conn, "dir/file1", []byte("file1 content"))
+
+	assert.Equal(t.T(), []string{"dir", "file"}, tk.NameList(conn, ""))
+	assert.Equal(t.T(), []string{"dir/file1"}, tk.NameList(conn, "dir"))
+	assert.Equal(t.T(), []string{"/dir/file1"}, tk.NameList(conn, "/dir"))
+	assert.Equal(t.T(), []string{"file"}, tk.NameList(conn, "file"))
+
+	tk.MustSuccess(conn, "cwd dir")
+	assert.Equal(t.T(), []string{"file1"}, tk.NameList(conn, ""))
+	assert.Equal(t.T(), []string{"../dir", "../file"}, tk.NameList(conn, ".."))
+
+	tk.MustSuccess(conn, "mkd empty")
+	tk.Send(conn, "nlst empty").Failure("No files found").EqualCode(450)
+	tk.Send(conn, "nlst missing").Failure("No such file or directory: /dir/missing").EqualCode(550)
+}
+
+func (t *ftpServerBaseCommandTest) TestListOptions() {
+	myConfig := *kit.DefaultServerSetting
+	tk := kit.NewTestKitWithConfig(t.T(), &myConfig)
+	defer tk.Stop()
+
+	conn := tk.AnonymousLogin()
+	tk.MustSuccess(conn, "mkd dir")
+	tk.MustSuccess(conn, "mkd dir/sub")
+	tk.MustSuccess(conn, "mkd incoming")
+	tk.Store(conn, ".hidden", []byte("hidden"))
+	tk.Store(conn, "a.csv", []byte("a"))
+	tk.Store(conn, "b.txt", []byte("bb"))
+	tk.Store(conn, "dir/c.csv", []byte("ccc"))
+	tk.Store(conn, "dir/sub/d.csv", []byte("dddd"))
+	tk.Store(conn, "incoming/e.csv", []byte("eeeee"))
+
+	// The options are not part of the path, and the hidden files are only listed with -a.
+	assert.Equal(t.T(), []string{"a.csv", "b.txt", "dir", "incoming"}, tk.NameList(conn, ""))
+	assert.Equal(t.T(), []string{".hidden", "a.csv", "b.txt", "dir", "incoming"}, tk.NameList(conn, "-a"))
+	assert.Equal(t.T(), []string{
+		"-rw-r--r--    1 anonymous anonymous            1 Jan  1  1970 a.csv",
+		"-rw-r--r--    1 anonymous anonymous            2 Jan  1  1970 b.txt",
+		"drwxr-xr-x    2 anonymous anonymous            0 Jan  1  1970 dir",
+		"drwxr-xr-x    2 anonymous anonymous            0 Jan  1  1970 incoming",
+	}, tk.List(conn, "-l"))
+	assert.Equal(t.T(), []string{
+		"-rw-r--r--    1 anonymous anonymous            3 Jan  1  1970 c.csv",
+		"drwxr-xr-x    2 anonymous anonymous            0 Jan  1  1970 sub",
+	}, tk.List(conn, "-la dir"))
+	assert.Equal(t.T(), "-rw-r--r--    1 anonymous anonymous            2 Jan  1  1970 b.txt\r\n"+
+		"-rw-r--r--    1 anonymous anonymous            1 Jan  1  1970 a.csv\r\n", tk.Listing(conn, "LIST -S [ab].*"))
+
+	// Glob patterns match the names of the entries.
+	assert.Equal(t.T(), []string{"a.csv"}, tk.NameList(conn, "*.csv"))
+	assert.Equal(t.T(), []string{"dir/c.csv"}, tk.NameList(conn, "dir/*.csv"))
+	assert.Equal(t.T(), []string{"/a.csv", "/b.txt"}, tk.NameList(conn, "/[ab].*"))
+	tk.Send(conn, "nlst *.pdf").Failure("No files found")
+
+	// The recursive listings skip the directories which can't be listed.
+	myConfig.Anonymous = &config.Anonymous{Enabled: true, Incoming: "/incoming"}
+	assert.Nil(t.T(), tk.Reload(&myConfig))
+	conn = tk.AnonymousLogin()
+	assert.Equal(t.T(), []string{
+		"a.csv", "b.txt", "dir", "dir/c.csv", "dir/sub", "dir/sub/d.csv", "incoming",
+	}, tk.NameList(conn, "-R"))
+	assert.Equal(t.T(), "dir:\r\n"+
+		"-rw-r--r--    1 anonymous anonymous            3 Jan  1  1970 c.csv\r\n"+
+		"drwxr-xr-x    2 anonymous anonymous            0 Jan  1  1970 sub\r\n"+
+		"\r\n"+
+		"dir/sub:\r\n"+
+		"-rw-r--r--    1 anonymous anonymous            4 Jan  1  1970 d.csv\r\n", tk.Listing(conn, "LIST -RS dir"))
+	tk.Send(conn, "stat -l dir/sub").Success(" Status of /dir/sub:\r\n" +
+		"-rw-r--r--    1 anonymous anonymous            4 Jan  1  1970 d.csv\r\n")
+	tk.Send(conn, "nlst -R incoming").Failure("Permission denied")
+
+	// The recursive listings are limited in depth and in entries.
+	myConfig.MaxListDepth = 1
+	assert.Nil(t.T(), tk.Reload(&myConfig))
+	conn = tk.AnonymousLogin()
+	assert.Equal(t.T(), []string{"a.csv", "b.txt", "dir", "dir/c.csv", "dir/sub", "incoming"}, tk.NameList(conn, "-R"))
+	myConfig.MaxListEntries = 3
+	assert.Nil(t.T(), tk.Reload(&myConfig))
+	conn = tk.AnonymousLogin()
+	passive := tk.PassiveConn(conn)
+	tk.Send(conn, "nlst -R").Wait().TakeAction(func() {
+		assert.Len(t.T(), strings.Split(string(tk.TransferConnReceive(passive)), "\r\n"), 4)
+	}).Success("Listing truncated to 3 entries")
+}
+
+func (t *ftpServerBaseCommandTest) TestListingLimit() {
+	myConfig := *kit.DefaultServerSetting
+	myConfig.MaxListEntries = 3
+	tk := kit.NewTestKitWithConfig(t.T(), &myConfig)
+	defer tk.Stop()
+
+	conn := tk.AnonymousLogin()
+	for i := 0; i < 5; i++ {
+		tk.Store(conn, fmt.Sprintf("file%d", i), []byte("content"))
+	}
+
+	for _, cmd := range []string{"list", "nlst", "list -S"} {
+		passive := tk.PassiveConn(conn)
+		tk.Send(conn, cmd).Wait().TakeAction(func() {
+			data := string(tk.TransferConnReceive(passive))
+			assert.Equal(t.T(), 3, strings.Count(data, "\r\n"), cmd)
+		}).Success("Listing truncated to 3 entries")
+	}
+	tk.Send(conn, "stat /").Success()
+
+	// The listings are not limited by default.
+	myConfig.MaxListEntries = 0
+	assert.Nil(t.T(), tk.Reload(&myConfig))
+	conn = tk.AnonymousLogin()
+	assert.Len(t.T(), tk.NameList(conn, ""), 5)
+}
+
+func (t *ftpServerBaseCommandTest) TestListFormat() {
+	myConfig := *kit.DefaultServerSetting
+	myConfig.FileMode = 0600
+	myConfig.DirMode = 0700
+	myConfig.Users = map[string]*config.User{
+		"alice": {Password: "alice"},
+	}
+	tk := kit.NewTestKitWithConfig(t.T(), &myConfig)
+	defer tk.Stop()
+
+	conn := tk.Login("alice", "alice")
+	tk.MustSuccess(conn, "mkd dir")
+	tk.Store(conn, "file", []byte("file content"))
+
+	// The memory storage has no modification time, the epoch is shown with its year.
+	assert.Equal(t.T(), []string{
+		"-rw-------    1 alice    alice              12 Jan  1  1970 file",
+		"drwx------    2 alice    alice               0 Jan  1  1970 dir",
+	}, tk.List(conn, ""))
+}
+
+func (t *ftpServerBaseCommandTest) TestAppend() {
+	myConfig := *kit.DefaultServerSetting
+	tk := kit.NewTestKitWithConfig(t.T(), &myConfig)
+	defer tk.Stop()
+	if !tk.SupportAppender() {
+		t.T().Skip("the storage is not an appender")
+	}
+
+	conn := tk.AnonymousLogin()
+	tk.Send(conn, "feat").Success(" These are my features\r\n" +
+		" UTF8\r\n SIZE\r\n MDTM\r\n REST STREAM\r\n APPE\r\n")
+	tk.MustSuccess(conn, "mkd dir")
+	tk.Store(conn, "stored", []byte("stored"))
+
+	// The files created by APPE are appended natively.
+	tk.Append(conn, "log", []byte("first\n"))
+	tk.Append(conn, "log", []byte("second\n"))
+	assert.Equal(t.T(), []byte("first\nsecond\n"), tk.Retrieve(conn, "log"))
+
+	// The other files are rewritten, up to MaxAppendRewriteSize.
+	tk.Send(conn, "appe stored").Failure("The storage can't append to /stored").EqualCode(502)
+	tk.Send(conn, "appe dir").Failure("/dir is a directory")
+
+	myConfig.MaxAppendRewriteSize = 10
+	assert.Nil(t.T(), tk.Reload(&myConfig))
+	conn = tk.AnonymousLogin()
+	tk.Append(conn, "stored", []byte(" more"))
+	assert.Equal(t.T(), []byte("stored more"), tk.Retrieve(conn, "stored"))
+	tk.Send(conn, "appe stored").Failure("The storage can't append to /stored")
+}
+
 func (t *ftpServerBaseCommandTest) TestListFiles() {
 	tk := kit.NewTestKit(t.T())
 	defer tk.Stop()
@@ -136,8 +707,8 @@ func (t *ftpServerBaseCommandTest) TestListFiles() {
 
 	fileList := tk.List(conn, "")
 	assert.Equal(t.T(), []string{
-		"d--------- 1 ftp ftp            0  Jan  1 00:00 test",
-		"d--------- 1 ftp ftp            0  Jan  1 00:00 test1",
+		"drwxr-xr-x    2 anonymous anonymous            0 Jan  1  1970 test",
+		"drwxr-xr-x    2 anonymous anonymous            0 Jan  1  1970 test1",
 	}, fileList)
 }
 
@@ -151,13 +722,13 @@ func (t *ftpServerBaseCommandTest) TestDeleteFile() {
 	tk.MustSuccess(conn, "mkd test1")
 	fileList := tk.List(conn, "")
 	assert.Equal(t.T(), []string{
-		"d--------- 1 ftp ftp            0  Jan  1 00:00 test",
-		"d--------- 1 ftp ftp            0  Jan  1 00:00 test1",
+		"drwxr-xr-x    2 anonymous anonymous            0 Jan  1  1970 test",
+		"drwxr-xr-x    2 anonymous anonymous            0 Jan  1  1970 test1",
 	}, fileList)
 	tk.MustSuccess(conn, "RMD test1")
 	fileList = tk.List(conn, "")
 	assert.Equal(t.T(), []string{
-		"d--------- 1 ftp ftp            0  Jan  1 00:00 test",
+		"drwxr-xr-x    2 anonymous anonymous            0 Jan  1  1970 test",
 	}, fileList)
 }
 
@@ -170,7 +741,7 @@ func (t *ftpServerBaseCommandTest) TestRenameFile() {
 	tk.Store(conn, "file1", []byte("file1 content"))
 	fileList := tk.List(conn, "")
 	assert.Equal(t.T(), []string{
-		"-rwxrwxrwx 1 ftp ftp           13  Jan  1 00:00 file1",
+		"-rw-r--r--    1 anonymous anonymous           13 Jan  1  1970 file1",
 	}, fileList)
 
 	tk.MustSuccess(conn, "rnfr file1")
@@ -178,7 +749,7 @@ func (t *ftpServerBaseCommandTest) TestRenameFile() {
 
 	fileList = tk.List(conn, "")
 	assert.Equal(t.T(), []string{
-		"-rwxrwxrwx 1 ftp ftp           13  Jan  1 00:00 test",
+		"-rw-r--r--    1 anonymous anonymous           13 Jan  1  1970 test",
 	}, fileList)
 
 	tk.MustFailure(conn, "rnto test1")
@@ -193,8 +764,8 @@ func (t *ftpServerBaseCommandTest) TestStoreFile() {
 	tk.Store(conn, "file1", []byte("file1 content"))
 	fileList := tk.List(conn, "")
 	assert.Equal(t.T(), []string{
-		"-rwxrwxrwx 1 ftp ftp           13  Jan  1 00:00 file1",
-		"d--------- 1 ftp ftp            0  Jan  1 00:00 test",
+		"-rw-r--r--    1 anonymous anonymous           13 Jan  1  1970 file1",
+		"drwxr-xr-x    2 anonymous anonymous            0 Jan  1  1970 test",
 	}, fileList)
 	tk.Send(conn, "size file1").Success("13")
 
@@ -119,9 +119,10 @@ func (m *mockConn) Read(p []byte) (n int, err error) {
 	return 1, nil
 }
 
+// Close closes the connection, it could be called several times like net.Conn.
+// The channels are left open, the writes after Close fail with an error.
 func (m *mockConn) Close() error {
 	m.cancelF()
-	close(m.w)
 	return nil
 }
 
@@ -54,7 +54,8 @@ type model struct {
 	conn     utils.Conn
 	tpMapper func(t code) state
 
-	respMsg []string
+	respMsg  []string
+	respCode []code
 }
 
 func (m *model) Begin(cmd string) *model {
@@ -72,6 +73,7 @@ func (m *model) ExpectWithMsg(message string, states ...state) *model {
 		assert.Equal(m.t, message, msg)
 	}
 	m.respMsg = append(m.respMsg, msg)
+	m.respCode = append(m.respCode, c)
 	curState := m.tpMapper(c)
 
 	anyOf := utils.AnyOf(states, func(i int) bool {
@@ -128,6 +130,12 @@ func (m *model) Auto() *model {
 	panic("no reachable")
 }
 
+// EqualCode asserts the code of the last response.
+func (m *model) EqualCode(c int) *model {
+	assert.Equal(m.t, code(c), m.respCode[len(m.respCode)-1])
+	return m
+}
+
 func (m *model) message() []string {
 	return m.respMsg
 }
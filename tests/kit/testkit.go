@@ -2,6 +2,7 @@ package kit
 
 import (
 	"bufio"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -10,12 +11,15 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/beyondstorage/go-storage/v4/types"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap"
 
+	"github.com/beyondstorage/beyond-ftp/auth"
 	"github.com/beyondstorage/beyond-ftp/client"
 	"github.com/beyondstorage/beyond-ftp/cmd"
 	"github.com/beyondstorage/beyond-ftp/config"
@@ -60,25 +64,77 @@ type Hook struct {
 type MockServer struct {
 	listener chan interface{}
 
-	cm       *connManager
-	setting  *config.ServerSettings
-	storager types.Storager
+	cm            *connManager
+	mu            sync.RWMutex
+	setting       *config.ServerSettings
+	storager      types.Storager
+	storagers     *utils.StoragerPool
+	authenticator auth.Authenticator
+	custom        bool
 }
 
 func (m *MockServer) Storager() types.Storager {
 	return m.storager
 }
 
+func (m *MockServer) UserStorager(service string) (types.Storager, error) {
+	if service == m.Setting().Service {
+		return m.storager, nil
+	}
+	return m.storagers.Get(service)
+}
+
 func (m *MockServer) Setting() *config.ServerSettings {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	return m.setting
 }
 
+func (m *MockServer) Reload(setting *config.ServerSettings) error {
+	if err := m.Setting().CheckReload(setting); err != nil {
+		return err
+	}
+	m.mu.RLock()
+	current, custom := m.authenticator, m.custom
+	m.mu.RUnlock()
+	authenticator, err := server.ReloadAuthenticator(current, custom, setting)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.setting = setting
+	m.authenticator = authenticator
+	return nil
+}
+
+func (m *MockServer) TLSConfig() *tls.Config {
+	return nil
+}
+
+func (m *MockServer) Authenticator() auth.Authenticator {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.authenticator
+}
+
 func NewMockServer(listener chan interface{}, cm *connManager, setting *config.ServerSettings) (*MockServer, error) {
 	storager, err := utils.NewStoragerFromString(setting.Service)
 	if err != nil {
 		return nil, err
 	}
-	return &MockServer{listener: listener, cm: cm, setting: setting, storager: storager}, nil
+	authenticator, err := server.NewAuthenticator(setting)
+	if err != nil {
+		return nil, err
+	}
+	return &MockServer{
+		listener:      listener,
+		cm:            cm,
+		setting:       setting,
+		storager:      storager,
+		storagers:     utils.NewStoragerPool(),
+		authenticator: authenticator,
+	}, nil
 }
 
 func (m *MockServer) Start() {
@@ -98,14 +154,14 @@ func (m *MockServer) AcceptClient() (utils.Conn, string, error) {
 	return conn, addr.(string), nil
 }
 
-func (m *MockServer) PassiveTransferFactory(listenHost string, portRange *config.PortRange) (transfer.Handler, int, error) {
+func (m *MockServer) PassiveTransferFactory(listenHost string, portRange *config.PortRange, tlsConfig *tls.Config) (transfer.Handler, int, error) {
 	conn, i := m.cm.new()
 	return &mockPassiveHandler{
 		conn: conn,
 	}, i, nil
 }
 
-func (m *MockServer) ActiveTransferFactory(addr *net.TCPAddr) transfer.Handler {
+func (m *MockServer) ActiveTransferFactory(addr *net.TCPAddr, tlsConfig *tls.Config) transfer.Handler {
 	return &mockActiveHandler{
 		remoteAddr: addr,
 	}
@@ -136,16 +192,19 @@ var (
 			Start: 1204,
 			End:   2048,
 		},
-		Users: map[string]string{"anonymous": ""},
+		Banner:    "Welcome to BeyondFTP Server",
+		Users:     map[string]*config.User{},
+		Anonymous: &config.Anonymous{Enabled: true, Writable: true},
 	}
 )
 
 type TestKit struct {
 	t *testing.T
 
-	l  chan interface{}
-	s  server.Server
-	cm *connManager
+	l        chan interface{}
+	s        server.Server
+	cm       *connManager
+	sessions *client.Sessions
 }
 
 func NewTestKit(t *testing.T) *TestKit {
@@ -153,27 +212,62 @@ func NewTestKit(t *testing.T) *TestKit {
 }
 
 func NewTestKitWithConfig(t *testing.T, settings *config.ServerSettings) *TestKit {
+	return NewTestKitWithAuthenticator(t, settings, nil)
+}
+
+// NewTestKitWithAuthenticator creates a test kit whose server uses the authenticator,
+// the default one is used if it is nil. The authenticator is protected against
+// brute-force attacks as set by SetAuthenticator.
+func NewTestKitWithAuthenticator(t *testing.T, settings *config.ServerSettings, authenticator auth.Authenticator) *TestKit {
 	listener := make(chan interface{})
 	cm := newConnManager()
 	mockServer, err := NewMockServer(listener, cm, settings)
 	mustNil(err)
-	go cmd.StartServer(mockServer)
+	if authenticator != nil {
+		mockServer.authenticator = auth.NewGuard(authenticator, settings.LoginProtection)
+		mockServer.custom = true
+	}
+	sessions := client.NewSessions(mockServer.Setting)
+	go cmd.Serve(mockServer, sessions)
 
 	kit := &TestKit{
-		t:  t,
-		l:  listener,
-		s:  mockServer,
-		cm: cm,
+		t:        t,
+		l:        listener,
+		s:        mockServer,
+		cm:       cm,
+		sessions: sessions,
 	}
 	return kit
 }
 
 func (k *TestKit) Dail() utils.Conn {
-	k.l <- ""
+	conn, _ := k.DailFrom("")
+	return conn
+}
+
+// DailFrom connects to the server from the remote address, and returns the
+// connection with the greeting of the server.
+func (k *TestKit) DailFrom(addr string) (utils.Conn, *Result) {
+	k.l <- addr
 	p := <-k.l
 	conn := k.cm.connect(p.(int))
-	response(bufio.NewReader(conn))
-	return conn
+	c, msg := response(bufio.NewReader(conn))
+	return conn, &Result{t: k.t, code: int(c), msg: msg}
+}
+
+// WaitSessions waits until the server counts n connections, the sessions are
+// released after the clients are disconnected.
+func (k *TestKit) WaitSessions(n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for k.sessions.Count() != n && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(k.t, n, k.sessions.Count())
+}
+
+// Reload replaces the settings of the server.
+func (k *TestKit) Reload(setting *config.ServerSettings) error {
+	return k.s.Reload(setting)
 }
 
 func (k *TestKit) Stop() {
@@ -257,6 +351,14 @@ func (k *TestKit) AnonymousLogin() utils.Conn {
 	return conn
 }
 
+// Login logs in with the user and the password.
+func (k *TestKit) Login(user, password string) utils.Conn {
+	conn := k.Dail()
+	k.Send(conn, "user "+user).Auto().Another()
+	k.Send(conn, "pass "+password).Auto().Success()
+	return conn
+}
+
 func (k *TestKit) Size(conn utils.Conn, path string) int {
 	size := k.Send(conn, fmt.Sprintf("SIZE %s", path)).Success().message()[0]
 	fileSize, err := strconv.Atoi(size)
@@ -265,13 +367,27 @@ func (k *TestKit) Size(conn utils.Conn, path string) int {
 }
 
 func (k *TestKit) List(conn utils.Conn, path string) []string {
+	return k.lines(k.Listing(conn, fmt.Sprintf("LIST %s", path)))
+}
+
+// NameList returns the sorted names sent by NLST.
+func (k *TestKit) NameList(conn utils.Conn, param string) []string {
+	return k.lines(k.Listing(conn, strings.TrimSpace(fmt.Sprintf("NLST %s", param))))
+}
+
+// Listing sends the listing command, and returns the data sent on the data connection.
+func (k *TestKit) Listing(conn utils.Conn, cmd string) string {
 	passive := k.PassiveConn(conn)
 
 	var data string
 
-	k.Send(conn, fmt.Sprintf("LIST %s", path)).Expect(wait).TakeAction(func() {
+	k.Send(conn, cmd).Expect(wait).TakeAction(func() {
 		data = string(k.TransferConnReceive(passive))
 	}).Success()
+	return data
+}
+
+func (k *TestKit) lines(data string) []string {
 	data = data[:len(data)-2]
 	fileList := strings.Split(data, "\r\n")
 	sort.Strings(fileList)
@@ -290,6 +406,10 @@ func (k *TestKit) Store(conn utils.Conn, path string, data []byte) {
 	k.transferFile(conn, path, data, "STOR")
 }
 
+func (k *TestKit) Append(conn utils.Conn, path string, data []byte) {
+	k.transferFile(conn, path, data, "APPE")
+}
+
 func (k *TestKit) Retrieve(conn utils.Conn, path string) []byte {
 	passive := k.PassiveConn(conn)
 
@@ -301,6 +421,12 @@ func (k *TestKit) Retrieve(conn utils.Conn, path string) []byte {
 	return data
 }
 
+// Receive reads a reply of the server without sending any command.
+func (k *TestKit) Receive(conn utils.Conn) *Result {
+	c, msg := response(bufio.NewReader(conn))
+	return &Result{t: k.t, code: int(c), msg: msg}
+}
+
 func (k *TestKit) Abort(conn utils.Conn) {
 	k.Send(conn, "abot").Auto().Success()
 }
@@ -310,7 +436,7 @@ func (k *TestKit) Send(conn utils.Conn, cmd string) *model {
 	case client.ABOR, client.ALLO, client.DELE, client.CWD, client.CDUP, client.SMNT, client.HELP,
 		client.MODE, client.NOOP, client.PASV, client.QUIT, client.SITE, client.PORT, client.SYST,
 		client.STAT, client.RMD, client.MKD, client.PWD, client.STRU, client.TYPE,
-		client.MDTM, client.SIZE, client.FEAT:
+		client.MDTM, client.SIZE, client.FEAT, client.AUTH, client.PBSZ, client.PROT:
 		return replyModel(k.t, conn).Begin(cmd)
 	case client.APPE, client.LIST, client.NLST, client.REIN, client.RETR, client.STOR, client.STOU:
 		return waitReplyModel(k.t, conn).Begin(cmd)
@@ -0,0 +1,82 @@
+package kit
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"time"
+)
+
+// NewTLSConfigPair generates a self-signed certificate, and returns the TLS
+// config used by the server and a client config that trusts it.
+func NewTLSConfigPair() (*tls.Config, *tls.Config) {
+	cert, pool := NewCertificate("localhost", x509.ExtKeyUsageServerAuth)
+
+	serverConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}
+	clientConfig := &tls.Config{
+		RootCAs:    pool,
+		ServerName: "localhost",
+	}
+	return serverConfig, clientConfig
+}
+
+// NewCertificate generates a self-signed certificate for the name, and returns
+// it with a cert pool that trusts it.
+func NewCertificate(name string, usage x509.ExtKeyUsage) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	mustNil(err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{name},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{usage},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	mustNil(err)
+	cert, err := x509.ParseCertificate(der)
+	mustNil(err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}, pool
+}
+
+// WriteTLSCertificate writes the certificate and the private key of the config
+// into the dir in PEM format.
+func WriteTLSCertificate(dir string, config *tls.Config) (string, string) {
+	cert := config.Certificates[0]
+	key, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	mustNil(err)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	mustNil(ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600))
+	mustNil(ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key}), 0600))
+	return certFile, keyFile
+}
+
+// FreePort returns a local port which is available for listening.
+func FreePort() int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	mustNil(err)
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
@@ -0,0 +1,313 @@
+package tests
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/beyondstorage/beyond-ftp/client"
+	"github.com/beyondstorage/beyond-ftp/cmd"
+	"github.com/beyondstorage/beyond-ftp/config"
+	"github.com/beyondstorage/beyond-ftp/server"
+	"github.com/beyondstorage/beyond-ftp/tests/kit"
+	"github.com/beyondstorage/beyond-ftp/utils"
+)
+
+// tlsControlConn is a control connection which could be upgraded to TLS,
+// the test kit works on mock connection which cannot negotiate TLS.
+type tlsControlConn struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func (c *tlsControlConn) send(cmd string) (int, string) {
+	_, err := c.conn.Write([]byte(cmd + "\r\n"))
+	assert.Nil(c.t, err)
+	return c.receive()
+}
+
+func (c *tlsControlConn) receive() (int, string) {
+	line, err := c.reader.ReadString('\n')
+	assert.Nil(c.t, err)
+	resp := strings.SplitN(strings.TrimRight(line, "\r\n"), " ", 2)
+	code, err := strconv.Atoi(resp[0])
+	assert.Nil(c.t, err)
+	return code, resp[1]
+}
+
+func (c *tlsControlConn) upgrade(config *tls.Config) {
+	c.conn = tls.Client(c.conn, config)
+	c.reader = bufio.NewReader(c.conn)
+}
+
+func (c *tlsControlConn) passive(config *tls.Config) net.Conn {
+	code, msg := c.send("EPSV")
+	assert.Equal(c.t, client.StatusEnteringEPSV, code)
+	var port int
+	_, err := fmt.Sscanf(msg, "Entering Extended Passive Mode (|||%d|)", &port)
+	assert.Nil(c.t, err)
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	assert.Nil(c.t, err)
+	if config != nil {
+		return tls.Client(conn, config)
+	}
+	return conn
+}
+
+func (t *ftpServerBaseCommandTest) newTLSControlConn(tlsConfig *tls.Config, setting config.ServerSettings) *tlsControlConn {
+	storager, err := utils.NewStoragerFromString(setting.Service)
+	assert.Nil(t.T(), err)
+	utils.StartStream(storager)
+
+	authenticator, err := server.NewAuthenticator(&setting)
+	assert.Nil(t.T(), err)
+
+	s := &server.FTPServer{}
+	clientConn, serverConn := net.Pipe()
+	h := client.NewHandler("tls", "pipe", serverConn, &setting, storager, utils.NewStoragerPool().Get,
+		s.PassiveTransferFactory, s.ActiveTransferFactory, tlsConfig, authenticator, nil)
+	go func() {
+		h.WriteMessage(client.StatusServiceReady, "Welcome")
+		h.HandleCommands()
+	}()
+
+	c := &tlsControlConn{t: t.T(), conn: clientConn, reader: bufio.NewReader(clientConn)}
+	code, _ := c.receive()
+	assert.Equal(t.T(), client.StatusServiceReady, code)
+	return c
+}
+
+func (t *ftpServerBaseCommandTest) TestExplicitTLS() {
+	serverConfig, clientConfig := kit.NewTLSConfigPair()
+	c := t.newTLSControlConn(serverConfig, *kit.DefaultServerSetting)
+
+	code, _ := c.send("PROT P")
+	assert.Equal(t.T(), client.StatusBadCommandSequence, code)
+	code, _ = c.send("AUTH KERBEROS")
+	assert.Equal(t.T(), client.StatusNotImplementedParam, code)
+	code, _ = c.send("AUTH TLS")
+	assert.Equal(t.T(), client.StatusAuthAccepted, code)
+	c.upgrade(clientConfig)
+
+	code, _ = c.send("USER anonymous")
+	assert.Equal(t.T(), client.StatusUserOK, code)
+	code, _ = c.send("PASS")
+	assert.Equal(t.T(), client.StatusUserLoggedIn, code)
+	code, _ = c.send("PBSZ 0")
+	assert.Equal(t.T(), client.StatusOK, code)
+	code, _ = c.send("PROT S")
+	assert.Equal(t.T(), client.StatusProtLevelNotSupported, code)
+	code, _ = c.send("PROT P")
+	assert.Equal(t.T(), client.StatusOK, code)
+
+	content := []byte("file content over tls")
+	data := c.passive(clientConfig)
+	code, _ = c.send("STOR file")
+	assert.Equal(t.T(), client.StatusFileStatusOK, code)
+	_, err := data.Write(content)
+	assert.Nil(t.T(), err)
+	assert.Nil(t.T(), data.Close())
+	code, _ = c.receive()
+	assert.Equal(t.T(), client.StatusClosingDataConn, code)
+
+	data = c.passive(clientConfig)
+	code, _ = c.send("RETR file")
+	assert.Equal(t.T(), client.StatusFileStatusOK, code)
+	received, err := ioutil.ReadAll(data)
+	assert.Nil(t.T(), err)
+	assert.Equal(t.T(), content, received)
+	code, _ = c.receive()
+	assert.Equal(t.T(), client.StatusClosingDataConn, code)
+
+	code, _ = c.send("PROT C")
+	assert.Equal(t.T(), client.StatusOK, code)
+	data = c.passive(nil)
+	code, _ = c.send("RETR file")
+	assert.Equal(t.T(), client.StatusFileStatusOK, code)
+	received, err = ioutil.ReadAll(data)
+	assert.Nil(t.T(), err)
+	assert.Equal(t.T(), content, received)
+	code, _ = c.receive()
+	assert.Equal(t.T(), client.StatusClosingDataConn, code)
+
+	code, _ = c.send("QUIT")
+	assert.Equal(t.T(), client.StatusClosingControlConn, code)
+}
+
+func (t *ftpServerBaseCommandTest) TestImplicitTLS() {
+	serverConfig, clientConfig := kit.NewTLSConfigPair()
+	certFile, keyFile := kit.WriteTLSCertificate(t.T().TempDir(), serverConfig)
+
+	c, err := config.LoadConfigFromFilepath("")
+	assert.Nil(t.T(), err)
+	c.Service = kit.DefaultServerSetting.Service
+	c.ListenHost = "127.0.0.1"
+	c.ListenPort = kit.FreePort()
+	c.ImplicitTLSPort = kit.FreePort()
+	c.TLSCertFile = certFile
+	c.TLSKeyFile = keyFile
+	c.Anonymous = &config.Anonymous{Enabled: true}
+	s, err := server.NewFTPServer(c)
+	assert.Nil(t.T(), err)
+	go cmd.StartServer(s)
+	defer s.Stop()
+
+	var conn net.Conn
+	assert.Eventually(t.T(), func() bool {
+		conn, err = tls.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", c.ImplicitTLSPort), clientConfig)
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+
+	ctrl := &tlsControlConn{t: t.T(), conn: conn, reader: bufio.NewReader(conn)}
+	code, _ := ctrl.receive()
+	assert.Equal(t.T(), client.StatusServiceReady, code)
+	code, _ = ctrl.send("AUTH TLS")
+	assert.Equal(t.T(), client.StatusBadCommandSequence, code)
+	code, _ = ctrl.send("USER anonymous")
+	assert.Equal(t.T(), client.StatusUserOK, code)
+	code, _ = ctrl.send("PASS")
+	assert.Equal(t.T(), client.StatusUserLoggedIn, code)
+
+	// The data connection is protected by default for implicit FTPS.
+	data := ctrl.passive(clientConfig)
+	code, _ = ctrl.send("LIST")
+	assert.Equal(t.T(), client.StatusFileStatusOK, code)
+	_, err = ioutil.ReadAll(data)
+	assert.Nil(t.T(), err)
+	code, _ = ctrl.receive()
+	assert.Equal(t.T(), client.StatusClosingDataConn, code)
+
+	// The explicit listener is still available in clear.
+	conn, err = net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", c.ListenPort))
+	assert.Nil(t.T(), err)
+	ctrl = &tlsControlConn{t: t.T(), conn: conn, reader: bufio.NewReader(conn)}
+	code, _ = ctrl.receive()
+	assert.Equal(t.T(), client.StatusServiceReady, code)
+	code, _ = ctrl.send("AUTH TLS")
+	assert.Equal(t.T(), client.StatusAuthAccepted, code)
+	ctrl.upgrade(clientConfig)
+	code, _ = ctrl.send("QUIT")
+	assert.Equal(t.T(), client.StatusClosingControlConn, code)
+}
+
+func (t *ftpServerBaseCommandTest) TestRequireTLS() {
+	serverConfig, clientConfig := kit.NewTLSConfigPair()
+	setting := *kit.DefaultServerSetting
+	setting.RequireTLS = true
+	setting.RequireDataTLS = true
+	optional := false
+	setting.Users = map[string]*config.User{
+		"partner": {Password: "partner"},
+		"script":  {Password: "script", RequireTLS: &optional, RequireDataTLS: &optional},
+	}
+
+	c := t.newTLSControlConn(serverConfig, setting)
+	code, _ := c.send("USER partner")
+	assert.Equal(t.T(), client.StatusRequestDeniedPolicy, code)
+	code, _ = c.send("PASS partner")
+	assert.Equal(t.T(), client.StatusBadCommandSequence, code)
+	code, _ = c.send("AUTH TLS")
+	assert.Equal(t.T(), client.StatusAuthAccepted, code)
+	c.upgrade(clientConfig)
+	code, _ = c.send("USER partner")
+	assert.Equal(t.T(), client.StatusUserOK, code)
+	code, _ = c.send("PASS partner")
+	assert.Equal(t.T(), client.StatusUserLoggedIn, code)
+
+	// The data connection is refused until PROT P.
+	data := c.passive(nil)
+	code, _ = c.send("LIST")
+	assert.Equal(t.T(), client.StatusCannotOpenDataConnection, code)
+	data.Close()
+	code, _ = c.send("PBSZ 0")
+	assert.Equal(t.T(), client.StatusOK, code)
+	// A data connection declared before PROT P is not upgraded.
+	data = c.passive(nil)
+	code, _ = c.send("PROT P")
+	assert.Equal(t.T(), client.StatusOK, code)
+	code, _ = c.send("LIST")
+	assert.Equal(t.T(), client.StatusCannotOpenDataConnection, code)
+	data.Close()
+	data = c.passive(clientConfig)
+	code, _ = c.send("LIST")
+	assert.Equal(t.T(), client.StatusFileStatusOK, code)
+	_, err := ioutil.ReadAll(data)
+	assert.Nil(t.T(), err)
+	code, _ = c.receive()
+	assert.Equal(t.T(), client.StatusClosingDataConn, code)
+
+	// The users with plain FTP allowed are not affected.
+	tk := kit.NewTestKitWithConfig(t.T(), &setting)
+	defer tk.Stop()
+	conn := tk.Dail()
+	tk.MustFailure(conn, "user partner")
+	tk.MustSuccess(conn, "user script")
+	tk.MustSuccess(conn, "pass script")
+	tk.Store(conn, "file", []byte("content"))
+}
+
+func (t *ftpServerBaseCommandTest) TestClientCertificate() {
+	serverConfig, clientConfig := kit.NewTLSConfigPair()
+	clientCert, clientPool := kit.NewCertificate("machine.partner", x509.ExtKeyUsageClientAuth)
+	serverConfig.ClientCAs = clientPool
+	serverConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	clientConfig.Certificates = []tls.Certificate{clientCert}
+
+	setting := *kit.DefaultServerSetting
+	setting.Users = map[string]*config.User{
+		"machine": {ClientCert: config.ClientCertSufficient, CertNames: []string{"machine.partner"}},
+		"both":    {Password: "both", ClientCert: config.ClientCertRequired, CertNames: []string{"CN=machine.partner"}},
+		"other":   {ClientCert: config.ClientCertSufficient, CertNames: []string{"other.partner"}},
+	}
+
+	c := t.newTLSControlConn(serverConfig, setting)
+	code, _ := c.send("USER machine")
+	assert.Equal(t.T(), client.StatusNotLoggedIn, code)
+	code, _ = c.send("AUTH TLS")
+	assert.Equal(t.T(), client.StatusAuthAccepted, code)
+	c.upgrade(clientConfig)
+	code, _ = c.send("USER other")
+	assert.Equal(t.T(), client.StatusNotLoggedIn, code)
+	code, _ = c.send("USER both")
+	assert.Equal(t.T(), client.StatusUserOK, code)
+	code, _ = c.send("PASS wrong")
+	assert.Equal(t.T(), client.StatusNotLoggedIn, code)
+	code, _ = c.send("USER both")
+	assert.Equal(t.T(), client.StatusUserOK, code)
+	code, _ = c.send("PASS both")
+	assert.Equal(t.T(), client.StatusUserLoggedIn, code)
+	code, _ = c.send("USER machine")
+	assert.Equal(t.T(), client.StatusUserLoggedInSecure, code)
+	code, _ = c.send("PWD")
+	assert.Equal(t.T(), client.StatusPathCreated, code)
+
+	// Without client certificate, the user cannot log in even with the password.
+	clientConfig.Certificates = nil
+	c = t.newTLSControlConn(serverConfig, setting)
+	code, _ = c.send("AUTH TLS")
+	assert.Equal(t.T(), client.StatusAuthAccepted, code)
+	c.upgrade(clientConfig)
+	code, _ = c.send("USER both")
+	assert.Equal(t.T(), client.StatusNotLoggedIn, code)
+}
+
+func (t *ftpServerBaseCommandTest) TestTLSNotConfigured() {
+	tk := kit.NewTestKit(t.T())
+	defer tk.Stop()
+
+	conn := tk.Dail()
+	tk.MustFailure(conn, "auth tls")
+	tk.MustFailure(conn, "pbsz 0")
+	tk.MustFailure(conn, "prot p")
+}
@@ -1,6 +1,7 @@
 package transfer
 
 import (
+	"crypto/tls"
 	"fmt"
 	"net"
 	"time"
@@ -11,6 +12,7 @@ import (
 // ActiveHandler handles active connection.
 type ActiveHandler struct {
 	RemoteAddr *net.TCPAddr // remote address of the client
+	TLSConfig  *tls.Config  // TLS config of the connection, nil for plain connection
 
 	conn net.Conn
 }
@@ -22,6 +24,16 @@ func (a *ActiveHandler) Open() (utils.Conn, error) {
 		return nil, fmt.Errorf("could not establish active connection: %v", err)
 	}
 
+	// The server side of the data connection always acts as the TLS server.
+	if a.TLSConfig != nil {
+		tlsConn := tls.Server(conn, a.TLSConfig)
+		if err = tlsConn.Handshake(); err != nil {
+			tlsConn.Close()
+			return nil, fmt.Errorf("could not establish active connection: %v", err)
+		}
+		conn = tlsConn
+	}
+
 	// Keep connection as it will be closed by Close().
 	a.conn = conn
 
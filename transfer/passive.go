@@ -1,6 +1,7 @@
 package transfer
 
 import (
+	"crypto/tls"
 	"net"
 	"time"
 
@@ -37,10 +38,19 @@ func (p *PassiveHandler) ConnectionWait(wait time.Duration) (net.Conn, error) {
 		if err != nil {
 			return nil, err
 		}
-		p.connection, err = p.Listener.Accept()
+		conn, err := p.Listener.Accept()
 		if err != nil {
 			return nil, err
 		}
+		// Complete the handshake before the transfer, the client is reset
+		// otherwise if the connection is closed without any data written.
+		if tlsConn, ok := conn.(*tls.Conn); ok {
+			if err = tlsConn.Handshake(); err != nil {
+				tlsConn.Close()
+				return nil, err
+			}
+		}
+		p.connection = conn
 	}
 
 	return p.connection, nil
@@ -29,3 +29,13 @@ func ParseRemoteAddr(param string) *net.TCPAddr {
 	addr, _ := net.ResolveTCPAddr("tcp", fmt.Sprintf("%s:%d", ip, port))
 	return addr
 }
+
+// AddrIP returns the IP of the address in the host:port format, or nil if
+// the host is not an IP.
+func AddrIP(addr string) net.IP {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return net.ParseIP(host)
+}
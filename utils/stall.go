@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// ErrStalled is returned by the operations on a StallConn after it stalled.
+var ErrStalled = errors.New("connection stalled")
+
+// StallConn closes the connection when no byte is read or written on it for
+// the timeout, so that the transfers blocked on it are aborted.
+type StallConn struct {
+	Conn
+
+	timeout time.Duration
+	timer   *time.Timer
+	stalled int32
+}
+
+// NewStallConn watches the connection with the timeout.
+func NewStallConn(conn Conn, timeout time.Duration) *StallConn {
+	s := &StallConn{Conn: conn, timeout: timeout}
+	s.timer = time.AfterFunc(timeout, func() {
+		atomic.StoreInt32(&s.stalled, 1)
+		s.Conn.Close()
+	})
+	return s
+}
+
+// Read implements io.Reader.
+func (s *StallConn) Read(p []byte) (int, error) {
+	n, err := s.Conn.Read(p)
+	return n, s.progress(n, err)
+}
+
+// Write implements io.Writer.
+func (s *StallConn) Write(p []byte) (int, error) {
+	n, err := s.Conn.Write(p)
+	return n, s.progress(n, err)
+}
+
+// Close implements io.Closer.
+func (s *StallConn) Close() error {
+	s.timer.Stop()
+	return s.Conn.Close()
+}
+
+// Stalled reports whether the connection has been closed by the timeout.
+func (s *StallConn) Stalled() bool {
+	return atomic.LoadInt32(&s.stalled) == 1
+}
+
+func (s *StallConn) progress(n int, err error) error {
+	// The closed connection may end with io.EOF, which must not complete the transfer.
+	if s.Stalled() {
+		return ErrStalled
+	}
+	if n > 0 {
+		s.timer.Reset(s.timeout)
+	}
+	return err
+}
@@ -0,0 +1,34 @@
+package utils
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStallConn(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	s := NewStallConn(server, 50*time.Millisecond)
+
+	go func() {
+		for i := 0; i < 3; i++ {
+			time.Sleep(30 * time.Millisecond)
+			client.Write([]byte{byte(i)})
+		}
+	}()
+	b := make([]byte, 1)
+	for i := 0; i < 3; i++ {
+		_, err := io.ReadFull(s, b)
+		assert.Nil(t, err)
+		assert.Equal(t, byte(i), b[0])
+	}
+	assert.False(t, s.Stalled())
+
+	_, err := s.Read(b)
+	assert.Equal(t, ErrStalled, err)
+	assert.True(t, s.Stalled())
+}
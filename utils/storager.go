@@ -2,9 +2,12 @@ package utils
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	_ "github.com/beyondstorage/go-service-memory"
 	"github.com/beyondstorage/go-storage/v4/services"
@@ -16,17 +19,72 @@ const (
 	upperStorageConnString = "memory://"
 )
 
+// flushInterval is the interval to check whether the uploads through the streams are persisted.
+const flushInterval = 100 * time.Millisecond
+
 var (
-	s        *stream.Stream
+	streams  sync.Map // types.Storager -> *stream.Stream
 	branchId uint64
+	// pendingBranches is the number of the branches neither completed nor closed.
+	pendingBranches int64
 )
 
 func NewStoragerFromString(connString string) (types.Storager, error) {
 	return services.NewStoragerFromString(connString)
 }
 
+// StoragerPool creates the storagers of the connection strings lazily, and
+// shares them between the sessions.
+type StoragerPool struct {
+	mu        sync.Mutex
+	storagers map[string]*pooledStorager
+}
+
+// pooledStorager is a storager of the pool, it is created once by the first
+// call getting it, the calls for other connection strings don't wait.
+type pooledStorager struct {
+	once     sync.Once
+	storager types.Storager
+	err      error
+}
+
+// NewStoragerPool creates an empty storager pool.
+func NewStoragerPool() *StoragerPool {
+	return &StoragerPool{storagers: make(map[string]*pooledStorager)}
+}
+
+// Get returns the storager of the connection string, it is created and its
+// stream is started on the first call. The creation is retried by the next
+// call if it fails.
+func (p *StoragerPool) Get(connString string) (types.Storager, error) {
+	p.mu.Lock()
+	s, ok := p.storagers[connString]
+	if !ok {
+		s = &pooledStorager{}
+		p.storagers[connString] = s
+	}
+	p.mu.Unlock()
+
+	s.once.Do(func() {
+		s.storager, s.err = NewStoragerFromString(connString)
+		if s.err == nil {
+			StartStream(s.storager)
+		}
+	})
+	if s.err != nil {
+		p.mu.Lock()
+		if p.storagers[connString] == s {
+			delete(p.storagers, connString)
+		}
+		p.mu.Unlock()
+		return nil, s.err
+	}
+	return s.storager, nil
+}
+
 type StoragerWriter struct {
-	b *stream.Branch
+	b    *stream.Branch
+	done sync.Once
 
 	path     string
 	storager types.Storager
@@ -46,17 +104,37 @@ func (x *StoragerWriter) ReadFrom(r io.Reader) (n int64, err error) {
 	return x.storager.Write(x.path, file, size)
 }
 
+// Complete persists the data written, it waits for the branch of the stream
+// to be persisted to the underlying storager.
 func (x *StoragerWriter) Complete() error {
 	if x.b != nil {
+		defer x.release()
 		return x.b.Complete()
 	}
 	return nil
 }
 
+// Close releases the writer if it is not completed, the data written is not
+// persisted.
+func (x *StoragerWriter) Close() error {
+	if x.b != nil {
+		x.release()
+	}
+	return nil
+}
+
+func (x *StoragerWriter) release() {
+	x.done.Do(func() {
+		atomic.AddInt64(&pendingBranches, -1)
+	})
+}
+
 func NewStoragerWriter(path string, storager types.Storager) *StoragerWriter {
-	if s != nil {
+	if v, ok := streams.Load(storager); ok {
+		s := v.(*stream.Stream)
 		b, err := s.StartBranch(atomic.AddUint64(&branchId, 1), path)
 		if err == nil {
+			atomic.AddInt64(&pendingBranches, 1)
 			return &StoragerWriter{b: b, storager: storager}
 		}
 	}
@@ -64,14 +142,31 @@ func NewStoragerWriter(path string, storager types.Storager) *StoragerWriter {
 	return &StoragerWriter{path: path, storager: storager}
 }
 
+// StartStream starts the stream persisting the uploads to under, the uploads
+// to other storagers are written directly.
 func StartStream(under types.Storager) {
-	var err error
-	s, err = newStream(stream.PersistMethodMultipart, under)
+	s, err := newStream(stream.PersistMethodMultipart, under)
 	if err == nil {
+		streams.Store(under, s)
 		s.Serve()
 	}
 }
 
+// FlushStreams waits for the uploads through the streams to be completed or
+// closed, it returns the error of the context if it is done before.
+func FlushStreams(ctx context.Context) error {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for atomic.LoadInt64(&pendingBranches) > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
 func newStream(persisMethod string, under types.Storager) (*stream.Stream, error) {
 	upper, err := NewStoragerFromString(fmt.Sprintf("%s/%s", upperStorageConnString, persisMethod))
 	if err != nil {
@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/beyondstorage/go-storage/v4/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStoragerPool(t *testing.T) {
+	p := NewStoragerPool()
+
+	// The concurrent calls share the storager created once.
+	storagers := make([]types.Storager, 8)
+	var wg sync.WaitGroup
+	for i := range storagers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s, err := p.Get("memory:///pool")
+			assert.Nil(t, err)
+			storagers[i] = s
+		}(i)
+	}
+	wg.Wait()
+	for _, s := range storagers {
+		assert.Same(t, storagers[0], s)
+	}
+
+	other, err := p.Get("memory:///other")
+	assert.Nil(t, err)
+	assert.NotSame(t, storagers[0], other)
+
+	// The failures are not kept.
+	_, err = p.Get("unknown:///pool")
+	assert.NotNil(t, err)
+	assert.NotContains(t, p.storagers, "unknown:///pool")
+}